@@ -9,6 +9,7 @@ require (
 	github.com/spf13/viper v1.18.2
 	github.com/zmb3/spotify/v2 v2.4.1
 	golang.org/x/oauth2 v0.16.0
+	golang.org/x/text v0.14.0
 )
 
 require (
@@ -32,7 +33,6 @@ require (
 	golang.org/x/exp v0.0.0-20240205201215-2c58cdc269a3 // indirect
 	golang.org/x/net v0.20.0 // indirect
 	golang.org/x/sys v0.17.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
 	google.golang.org/appengine v1.6.8 // indirect
 	google.golang.org/protobuf v1.32.0 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
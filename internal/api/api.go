@@ -0,0 +1,191 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zibbp/music-utils/internal/daemon"
+	"github.com/zibbp/music-utils/internal/file"
+)
+
+// Server serves the HTTP API of the daemon.
+type Server struct {
+	daemon *daemon.Daemon
+	// token is required as bearer token on /api routes when set
+	token string
+	// readOnly leaves out the routes that start jobs, without a token they are only served on loopback
+	readOnly bool
+}
+
+type syncRequest struct {
+	Playlist string `json:"playlist"`
+}
+
+type missingResponse struct {
+	Tidal     map[string][]file.MissingTrack          `json:"tidal"`
+	Navidrome map[string][]file.MissingTrackNavidrome `json:"navidrome"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// New creates the API served on address. Without a token the routes that start jobs are only registered
+// when address is a loopback address.
+func New(d *daemon.Daemon, token string, address string) *Server {
+	return &Server{daemon: d, token: token, readOnly: token == "" && !loopback(address)}
+}
+
+// loopback reports whether the listen address only accepts local connections.
+func loopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Register adds the routes to mux.
+func (s *Server) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", s.health)
+	mux.Handle("GET /api/jobs", s.auth(s.jobs))
+	mux.Handle("GET /api/jobs/{name}", s.auth(s.job))
+	mux.Handle("GET /api/runs", s.auth(s.runs))
+	mux.Handle("GET /api/missing", s.auth(s.missing))
+	if s.readOnly {
+		log.Warn().Msg("serve.api_token is not set, jobs can not be started through the API")
+		return
+	}
+	mux.Handle("POST /api/jobs/{name}/run", s.auth(s.runJob))
+	mux.Handle("POST /api/sync", s.auth(s.sync))
+}
+
+func (s *Server) auth(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next(w, r)
+	})
+}
+
+func (s *Server) health(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) jobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.daemon.Jobs())
+}
+
+func (s *Server) job(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	for _, job := range s.daemon.Jobs() {
+		if job.Name == name {
+			writeJSON(w, http.StatusOK, job)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, daemon.ErrUnknownJob)
+}
+
+func (s *Server) runJob(w http.ResponseWriter, r *http.Request) {
+	err := s.daemon.Trigger(r.PathValue("name"))
+	if err != nil {
+		writeError(w, triggerStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": daemon.StatusRunning})
+}
+
+// runs returns the runs newest first, limited with ?limit and filtered with ?job.
+func (s *Server) runs(w http.ResponseWriter, r *http.Request) {
+	history := s.daemon.History()
+	slices.Reverse(history)
+
+	job := r.URL.Query().Get("job")
+	if job != "" {
+		history = slices.DeleteFunc(history, func(run daemon.Run) bool {
+			return run.Job != job
+		})
+	}
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, errors.New("invalid limit"))
+			return
+		}
+		history = history[:min(n, len(history))]
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+// sync starts a sync of one playlist, given as ?playlist or in the JSON body, or of all playlists.
+func (s *Server) sync(w http.ResponseWriter, r *http.Request) {
+	request := syncRequest{Playlist: r.URL.Query().Get("playlist")}
+	if request.Playlist == "" && r.ContentLength > 0 {
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("invalid request body"))
+			return
+		}
+	}
+	err := s.daemon.TriggerSync(request.Playlist)
+	if err != nil {
+		writeError(w, triggerStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": daemon.StatusRunning})
+}
+
+func (s *Server) missing(w http.ResponseWriter, r *http.Request) {
+	var response missingResponse
+	var err error
+	response.Tidal, err = file.ReadMissingTracksByPlaylist()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	response.Navidrome, err = file.ReadMissingNavidromeTracksByPlaylist()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func triggerStatus(err error) int {
+	switch {
+	case errors.Is(err, daemon.ErrUnknownJob):
+		return http.StatusNotFound
+	case errors.Is(err, daemon.ErrBusy):
+		return http.StatusConflict
+	case errors.Is(err, daemon.ErrNotStarted):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Error().Err(err).Msg("Error writing response")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
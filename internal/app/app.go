@@ -0,0 +1,90 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"github.com/zibbp/music-utils/internal/filter"
+	"github.com/zibbp/music-utils/internal/matcher"
+	"github.com/zibbp/music-utils/internal/plan"
+	"github.com/zibbp/music-utils/internal/report"
+	"github.com/zibbp/music-utils/internal/tidal"
+	spotifyPkg "github.com/zmb3/spotify/v2"
+)
+
+// Options are shared by every command.
+type Options struct {
+	// Plan records the changes instead of making them when set
+	Plan *plan.Plan
+	// DeleteRemoved removes tracks that are no longer in the source playlist
+	DeleteRemoved bool
+	// Explain writes a trace of every Tidal match
+	Explain bool
+	// Filter selects the playlists to handle, nil handles all
+	Filter *filter.Filter
+	// Report collects the counts of the run when set
+	Report *report.Report
+	// Unattended fails when Spotify or Tidal is not authorized instead of waiting for a login
+	Unattended bool
+}
+
+type Playlists struct {
+	Playlists []Playlist `json:"playlists"`
+}
+
+type Playlist struct {
+	Spotify spotifyPkg.FullPlaylist `json:"spotify"`
+	Tidal   tidal.Playlist          `json:"tidal"`
+}
+
+func newMatcher() *matcher.Matcher {
+	return matcher.New(viper.GetFloat64("matcher.threshold"), viper.GetInt64("matcher.duration_tolerance"), viper.GetString("matcher.explicit_preference"))
+}
+
+// Commands are the commands that can run unattended, by subcommand name.
+var Commands = map[string]func(ctx context.Context, opts Options) error{
+	"spotify save":     SaveSpotify,
+	"spotify import":   ImportSpotify,
+	"tidal import":     ImportTidal,
+	"tidal save":       SaveTidal,
+	"navidrome export": ExportNavidrome,
+	"lidarr wanted":    LidarrWanted,
+}
+
+// ConfigOptions returns the options set in the config.
+func ConfigOptions() (Options, error) {
+	playlistFilter, err := filter.New(
+		viper.GetStringSlice("playlists.include"),
+		viper.GetStringSlice("playlists.exclude"),
+		viper.GetStringSlice("playlists.spotify_owners"),
+		viper.GetStringSlice("playlists.tidal_creators"),
+		viper.GetBool("playlists.owned_only"),
+	)
+	if err != nil {
+		return Options{}, fmt.Errorf("error reading playlist filters: %w", err)
+	}
+	return Options{
+		DeleteRemoved: viper.GetBool("sync.delete_removed"),
+		Filter:        playlistFilter,
+	}, nil
+}
+
+// SaveReport finishes the report of a run and writes it when reports are enabled. It returns the path of the report.
+func SaveReport(r *report.Report, err error) string {
+	if r == nil {
+		return ""
+	}
+	r.Finish(err)
+	if !viper.GetBool("reports.enabled") {
+		return ""
+	}
+	path, err := r.Write(viper.GetBool("reports.html"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error writing report")
+		return ""
+	}
+	log.Info().Msgf("Wrote report %s", path)
+	return path
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zibbp/music-utils/internal/file"
+	"github.com/zibbp/music-utils/internal/lidarr"
+	"github.com/zibbp/music-utils/internal/metrics"
+	"github.com/zibbp/music-utils/internal/report"
+	"github.com/zibbp/music-utils/internal/tidal"
+)
+
+// LidarrWanted looks up the wanted Lidarr albums on Tidal and writes their links to a file.
+func LidarrWanted(ctx context.Context, opts Options) error {
+	lidarrService, err := lidarr.InitializeService()
+	if err != nil {
+		return fmt.Errorf("error initializing lidarr service: %w", err)
+	}
+	tidalService, err := tidal.InitializeService(ctx, !opts.Unattended)
+	if err != nil {
+		return fmt.Errorf("error initializing tidal service: %w", err)
+	}
+	tidalService.Plan = opts.Plan
+	wantedAlbums, err := lidarrService.GetWanted()
+	if err != nil {
+		return fmt.Errorf("error getting wanted records: %w", err)
+	}
+	log.Info().Msgf("Found %d wanted albums", len(wantedAlbums))
+
+	var links []string
+	var missingAlbums []lidarr.Record
+	// Process each wanted album
+	for _, wantedAlbum := range wantedAlbums {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Find album on Tidal
+		tidalAlbum, err := tidalService.FindAlbum(ctx, wantedAlbum.Title, wantedAlbum.Artist.ArtistName)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error finding album %s", wantedAlbum.Title)
+			opts.Report.AddError("error finding album %s: %s", wantedAlbum.Title, err)
+			missingAlbums = append(missingAlbums, wantedAlbum)
+			if opts.Plan == nil {
+				metrics.Inc(metrics.LidarrAlbums, "result", "missing")
+			}
+			continue
+		}
+		if len(tidalAlbum.Albums.Items) == 0 {
+			log.Error().Msgf("Could not find album %s", wantedAlbum.Title)
+			missingAlbums = append(missingAlbums, wantedAlbum)
+			if opts.Plan == nil {
+				metrics.Inc(metrics.LidarrAlbums, "result", "missing")
+			}
+			continue
+		}
+		// Compare number of tracks
+		if tidalAlbum.Albums.Items[0].NumberOfTracks != wantedAlbum.Statistics.TrackCount {
+			log.Error().Msgf("Number of tracks for album %s does not match", wantedAlbum.Title)
+			missingAlbums = append(missingAlbums, wantedAlbum)
+			if opts.Plan == nil {
+				metrics.Inc(metrics.LidarrAlbums, "result", "missing")
+			}
+			continue
+		}
+		links = append(links, tidalAlbum.Albums.Items[0].URL)
+		if opts.Plan == nil {
+			metrics.Inc(metrics.LidarrAlbums, "result", "found")
+		}
+	}
+	opts.Report.SetAlbums(report.Albums{Wanted: len(wantedAlbums), Found: len(links), Missing: len(missingAlbums)})
+	// Write links to file
+	err = file.WriteWantedLinks(links)
+	if err != nil {
+		return fmt.Errorf("error writing wanted links to file: %w", err)
+	}
+	// Process missing albums
+	if len(missingAlbums) > 0 {
+		log.Info().Msgf("Found %d missing albums", len(missingAlbums))
+		err := file.ProcessMissingLidarrAlbums(missingAlbums)
+		if err != nil {
+			return fmt.Errorf("error processing missing albums: %w", err)
+		}
+	}
+	log.Info().Msgf("Finished writing wanted links to file")
+	return nil
+}
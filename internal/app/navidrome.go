@@ -0,0 +1,170 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"github.com/zibbp/music-utils/internal/file"
+	"github.com/zibbp/music-utils/internal/matcher"
+	"github.com/zibbp/music-utils/internal/metrics"
+	"github.com/zibbp/music-utils/internal/navidrome"
+	"github.com/zibbp/music-utils/internal/overrides"
+	"github.com/zibbp/music-utils/internal/report"
+	"github.com/zibbp/music-utils/internal/tidal"
+	"github.com/zibbp/music-utils/internal/utils"
+)
+
+// ExportNavidrome writes m3u8 playlist files for the saved Tidal playlists using Navidrome's database.
+func ExportNavidrome(ctx context.Context, opts Options) error {
+	navidromeService, err := navidrome.InitializeService()
+	if err != nil {
+		return fmt.Errorf("error initializing navidrome service: %w", err)
+	}
+	log.Info().Msg("Starting Navidrome import")
+	// Read Tidal playlist files
+	tidalPlaylists, err := file.ReadTidalPlaylists()
+	if err != nil {
+		return fmt.Errorf("error reading tidal playlists: %w", err)
+	}
+	log.Info().Msgf("Found %d Tidal playlists to import", len(tidalPlaylists))
+	trackOverrides, err := overrides.Load(overrides.Path)
+	if err != nil {
+		return fmt.Errorf("error loading overrides: %w", err)
+	}
+
+	for _, tidalPlaylist := range tidalPlaylists {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !opts.Filter.Tidal(tidalPlaylist.Title, strconv.FormatInt(tidalPlaylist.Creator.ID, 10), viper.GetString("tidal.user_id")) {
+			log.Debug().Msgf("Skipping playlist %s as it is filtered out", tidalPlaylist.Title)
+			continue
+		}
+		// Create m3u8 file
+		err := file.CreateM3U8PlaylistFile(tidalPlaylist.Title)
+		if err != nil {
+			log.Error().Err(err).Msg("Error creating m3u8 file")
+		}
+		log.Info().Msgf("Processing playlist %s which has %d tracks", tidalPlaylist.Title, len(tidalPlaylist.Tracks))
+		playlistReport := report.Playlist{Name: tidalPlaylist.Title, Target: report.TargetNavidrome, Source: len(tidalPlaylist.Tracks)}
+		// Loop tracks
+		var missingTracks []tidal.Track
+		var trackPaths []string
+		var matches []file.NavidromeMatch
+		found, failed := 0, 0
+		for _, track := range tidalPlaylist.Tracks {
+			// Check for a manual override
+			if foundTrack, ok := trackOverrides.NavidromePath(track.ID); ok {
+				log.Debug().Msgf("Using override %s for track %s", foundTrack, track.Title)
+				if opts.Plan == nil {
+					metrics.Inc(metrics.TracksMatched, "target", "navidrome", "strategy", "override")
+				}
+				found++
+				matches = append(matches, file.NavidromeMatch{TidalID: track.ID, Path: foundTrack})
+				if !slices.Contains(trackPaths, foundTrack) {
+					trackPaths = append(trackPaths, foundTrack)
+				}
+				continue
+			}
+			matchTrack := matcher.FromTidalTrack(track)
+			foundTrack, err := navidromeService.Db.FindTrack(track.Title, track.Album.Title, matchTrack.Artists, track.Duration, matchTrack.Version)
+			if err != nil {
+				log.Error().Err(err).Msgf("Error finding track %s", track.Title)
+				playlistReport.Errors = append(playlistReport.Errors, fmt.Sprintf("error finding track %s: %s", track.Title, err))
+				failed++
+				continue
+			}
+			if foundTrack != "" {
+				log.Debug().Msgf("Found track %s", track.Title)
+				if opts.Plan == nil {
+					metrics.Inc(metrics.TracksMatched, "target", "navidrome", "strategy", "database")
+				}
+				found++
+				matches = append(matches, file.NavidromeMatch{TidalID: track.ID, Path: foundTrack})
+				if !slices.Contains(trackPaths, foundTrack) {
+					trackPaths = append(trackPaths, foundTrack)
+				}
+			} else {
+				log.Debug().Msgf("Track %s not found", track.Title)
+				if opts.Plan == nil {
+					metrics.Inc(metrics.TracksMissing, "target", "navidrome")
+				}
+				missingTracks = append(missingTracks, track)
+			}
+		}
+		playlistReport.Matched, playlistReport.Missing = found, len(missingTracks)
+		// Only the lines of tracks that left the playlist are removed, tracks not found this time keep their line
+		previous, err := file.ReadNavidromeMatches(tidalPlaylist.Title)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error reading previous matches for %s", tidalPlaylist.Title)
+		}
+		remove := opts.DeleteRemoved
+		if remove && (err != nil || failed > 0) {
+			log.Warn().Msgf("Not removing tracks from playlist file %s as some tracks could not be looked up", tidalPlaylist.Title)
+			remove = false
+		}
+		removable, kept := removableM3U8Paths(tidalPlaylist.Tracks, matches, previous)
+		// Update m3u8 file
+		playlistDiff, err := utils.SyncM3U8Playlist(tidalPlaylist.Title, trackPaths, remove, removable)
+		if err != nil {
+			log.Error().Err(err).Msg("Error updating m3u8 file")
+			playlistReport.Errors = append(playlistReport.Errors, fmt.Sprintf("error updating m3u8 file: %s", err))
+		}
+		playlistReport.Added, playlistReport.Present = len(playlistDiff.Added), len(playlistDiff.Unchanged)
+		if remove {
+			playlistReport.Removed = len(playlistDiff.Removed)
+		}
+		err = file.WriteNavidromeMatches(append(matches, kept...), tidalPlaylist.Title)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error writing matches for %s", tidalPlaylist.Title)
+			playlistReport.Errors = append(playlistReport.Errors, fmt.Sprintf("error writing matches: %s", err))
+		}
+		opts.Report.AddPlaylist(playlistReport)
+		// Missing tracks
+		if len(missingTracks) > 0 {
+			log.Info().Msgf("Found %d missing tracks", len(missingTracks))
+			err := file.ProcessMissingNavidromeTracks(missingTracks, tidalPlaylist.Title)
+			if err != nil {
+				return fmt.Errorf("error processing missing tracks: %w", err)
+			}
+		}
+		log.Info().Msgf("Finished processing playlist %s", tidalPlaylist.Title)
+		if opts.Plan == nil {
+			metrics.Inc(metrics.PlaylistsProcessed, "command", "navidrome export")
+		}
+	}
+	return nil
+}
+
+// removableM3U8Paths returns the m3u8 lines of the previous export whose track left the playlist or now
+// has another line. It also returns the previous matches of tracks that are still in the playlist but were
+// not found this time, their lines are kept.
+func removableM3U8Paths(tracks []tidal.Track, matches []file.NavidromeMatch, previous []file.NavidromeMatch) (map[string]bool, []file.NavidromeMatch) {
+	inPlaylist := make(map[int64]bool, len(tracks))
+	for _, track := range tracks {
+		inPlaylist[track.ID] = true
+	}
+	resolved := make(map[int64]bool, len(matches))
+	for _, match := range matches {
+		resolved[match.TidalID] = true
+	}
+	var kept []file.NavidromeMatch
+	keep := make(map[string]bool)
+	for _, match := range previous {
+		if inPlaylist[match.TidalID] && !resolved[match.TidalID] {
+			kept = append(kept, match)
+			keep[match.Path] = true
+		}
+	}
+	removable := make(map[string]bool)
+	for _, match := range previous {
+		if !keep[match.Path] {
+			removable[match.Path] = true
+		}
+	}
+	return removable, kept
+}
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zibbp/music-utils/internal/notification"
+	"github.com/zibbp/music-utils/internal/plan"
+)
+
+// NotificationTargets returns the configured notification targets, it fails when there are none.
+func NotificationTargets() ([]notification.Target, error) {
+	targets, err := notification.Targets()
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("no notification targets configured")
+	}
+	return targets, nil
+}
+
+// Notify sends a notification for a finished run to every configured target that wants the event.
+// A failing target does not stop the others.
+func Notify(ctx context.Context, opts Options, event notification.Event) error {
+	targets, err := NotificationTargets()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, target := range targets {
+		if !target.Wants(event) {
+			log.Debug().Msgf("Skipping notification to %s as it does not want the event", target.Name)
+			continue
+		}
+		message, err := target.Render(event)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", target.Name, err))
+			continue
+		}
+		if opts.Plan != nil {
+			opts.Plan.Record(plan.ActionSendNotification, target.Name, message)
+			continue
+		}
+		log.Info().Msgf("Sending notification to %s", target.Name)
+		err = target.Send(ctx, event, message)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error sending notification to %s: %w", target.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"github.com/zibbp/music-utils/internal/file"
+	"github.com/zibbp/music-utils/internal/navidrome"
+	"github.com/zibbp/music-utils/internal/overrides"
+	"github.com/zibbp/music-utils/internal/review"
+	"github.com/zibbp/music-utils/internal/tidal"
+)
+
+// Review walks through missing and low confidence matches and saves the choices as overrides.
+func Review(ctx context.Context, opts Options, in io.Reader, out io.Writer) error {
+	trackOverrides, err := overrides.Load(overrides.Path)
+	if err != nil {
+		return fmt.Errorf("error loading overrides: %w", err)
+	}
+	reviewer := review.New(in, out, newMatcher(), trackOverrides, overrides.Path, viper.GetInt("review.candidates"), viper.GetFloat64("review.threshold"))
+
+	// Spotify to Tidal matches
+	matchedTracks, err := file.ReadMatchedTracks()
+	if err != nil {
+		log.Error().Err(err).Msg("Error reading matched tracks")
+	}
+	tidalTracks := reviewer.TidalTracks(matchedTracks)
+	log.Info().Msgf("Found %d Tidal tracks to review", len(tidalTracks))
+	if len(tidalTracks) > 0 {
+		tidalService, err := tidal.InitializeService(ctx, !opts.Unattended)
+		if err != nil {
+			return fmt.Errorf("error initializing tidal service: %w", err)
+		}
+		tidalService.Plan = opts.Plan
+		err = reviewer.Tidal(ctx, tidalService, tidalTracks)
+		if err != nil {
+			return fmt.Errorf("error reviewing Tidal tracks: %w", err)
+		}
+	}
+
+	// Tidal to Navidrome matches
+	missingTracks, err := file.ReadMissingNavidromeTracks()
+	if err != nil {
+		log.Error().Err(err).Msg("Error reading missing Navidrome tracks")
+	}
+	navidromeTracks := reviewer.NavidromeTracks(missingTracks)
+	log.Info().Msgf("Found %d Navidrome tracks to review", len(navidromeTracks))
+	if len(navidromeTracks) > 0 {
+		navidromeService, err := navidrome.InitializeService()
+		if err != nil {
+			return fmt.Errorf("error initializing navidrome service: %w", err)
+		}
+		err = reviewer.Navidrome(navidromeService.Db, navidromeTracks)
+		if err != nil {
+			return fmt.Errorf("error reviewing Navidrome tracks: %w", err)
+		}
+	}
+	log.Info().Msg("Finished reviewing tracks")
+	return nil
+}
@@ -0,0 +1,263 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"github.com/zibbp/music-utils/internal/file"
+	"github.com/zibbp/music-utils/internal/matcher"
+	"github.com/zibbp/music-utils/internal/metrics"
+	"github.com/zibbp/music-utils/internal/report"
+	"github.com/zibbp/music-utils/internal/spotify"
+	"github.com/zibbp/music-utils/internal/tidal"
+	spotifyPkg "github.com/zmb3/spotify/v2"
+)
+
+// SaveSpotify saves the Spotify playlists of the user to files.
+func SaveSpotify(ctx context.Context, opts Options) error {
+	log.Info().Msg("Saving Spotify playlists to files")
+	spotifyService, err := spotify.InitializeService(ctx, !opts.Unattended)
+	if err != nil {
+		return fmt.Errorf("error initializing spotify service: %w", err)
+	}
+	saved, err := spotifyService.SaveUserPlaylists(opts.Filter)
+	for _, playlist := range saved {
+		opts.Report.AddPlaylist(report.Playlist{Name: playlist.Name, Target: report.TargetSpotify, Source: len(playlist.Tracks.Tracks)})
+	}
+	if err != nil {
+		return fmt.Errorf("error saving user playlists: %w", err)
+	}
+	return nil
+}
+
+// AuthSpotify logs in to Spotify, or checks the saved session. With force the saved tokens are discarded first.
+func AuthSpotify(ctx context.Context, force bool) error {
+	if force {
+		viper.Set("spotify.access_token", "")
+		viper.Set("spotify.refresh_token", "")
+	}
+	_, err := spotify.InitializeService(ctx, true)
+	if err != nil {
+		return fmt.Errorf("error initializing spotify service: %w", err)
+	}
+	log.Info().Msg("Spotify is authorized")
+	return nil
+}
+
+// ImportSpotify imports the saved Tidal playlists to Spotify, creating missing playlists.
+// Tracks are only added, opts.DeleteRemoved does not apply.
+func ImportSpotify(ctx context.Context, opts Options) error {
+	spotifyService, err := spotify.InitializeService(ctx, !opts.Unattended)
+	if err != nil {
+		return fmt.Errorf("error initializing spotify service: %w", err)
+	}
+	spotifyService.Plan = opts.Plan
+	// Read local Tidal playlists from files
+	tidalPlaylists, err := file.ReadTidalPlaylists()
+	if err != nil {
+		return fmt.Errorf("error reading tidal playlists: %w", err)
+	}
+	if len(tidalPlaylists) == 0 {
+		return errors.New("no Tidal playlists found")
+	}
+	userID, err := spotifyService.CurrentUserID()
+	if err != nil {
+		return fmt.Errorf("error getting current spotify user: %w", err)
+	}
+	// Only playlists of the user can be changed
+	spotifyPlaylists, err := spotifyService.GetUserSimplePlaylists()
+	if err != nil {
+		return fmt.Errorf("error getting user spotify playlists: %w", err)
+	}
+	owned := make(map[string]spotifyPkg.SimplePlaylist)
+	for _, playlist := range spotifyPlaylists {
+		if _, ok := owned[playlist.Name]; !ok && playlist.Owner.ID == userID {
+			owned[playlist.Name] = playlist
+		}
+	}
+	log.Info().Msgf("Found %d Spotify playlists owned by %s", len(owned), userID)
+
+	playlistMatcher := newMatcher()
+	for _, tidalPlaylist := range tidalPlaylists {
+		if ctx.Err() != nil {
+			return fmt.Errorf("import to Spotify cancelled: %w", ctx.Err())
+		}
+		if !opts.Filter.Tidal(tidalPlaylist.Title, strconv.FormatInt(tidalPlaylist.Creator.ID, 10), viper.GetString("tidal.user_id")) {
+			log.Debug().Msgf("Skipping playlist %s as it is filtered out", tidalPlaylist.Title)
+			continue
+		}
+		playlist, ok := owned[tidalPlaylist.Title]
+		if !ok {
+			log.Info().Msgf("Playlist %s not found on Spotify", tidalPlaylist.Title)
+			created, err := spotifyService.CreatePlaylist(ctx, userID, tidalPlaylist.Title, tidalPlaylist.Description)
+			if err != nil {
+				log.Error().Err(err).Msgf("Error creating playlist %s on Spotify", tidalPlaylist.Title)
+				opts.Report.AddError("error creating playlist %s on Spotify: %s", tidalPlaylist.Title, err)
+				continue
+			}
+			log.Info().Msgf("Created playlist %s on Spotify", created.Name)
+			playlist = created.SimplePlaylist
+			owned[playlist.Name] = playlist
+		}
+		err := importSpotifyPlaylist(ctx, opts, spotifyService, playlistMatcher, tidalPlaylist, playlist)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importSpotifyPlaylist adds the tracks of a Tidal playlist to its Spotify playlist.
+func importSpotifyPlaylist(ctx context.Context, opts Options, spotifyService *spotify.Service, playlistMatcher *matcher.Matcher, tidalPlaylist tidal.Playlist, playlist spotifyPkg.SimplePlaylist) error {
+	log.Info().Msgf("Importing playlist %s to Spotify", tidalPlaylist.Title)
+	playlistReport := report.Playlist{Name: tidalPlaylist.Title, Target: report.TargetSpotify, Source: len(tidalPlaylist.Tracks)}
+	// Get the tracks already in the Spotify playlist
+	var current []matcher.Track
+	currentISRCs := make(map[string]bool)
+	if !spotify.IsPlanned(playlist.ID) {
+		tracks, err := spotifyService.GetPlaylistTracks(playlist.ID)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error getting playlist tracks for %s", playlist.Name)
+			playlistReport.Errors = append(playlistReport.Errors, fmt.Sprintf("error getting playlist tracks: %s", err))
+			opts.Report.AddPlaylist(playlistReport)
+			return nil
+		}
+		for _, track := range tracks {
+			// Episodes and local files have no track
+			if track == nil {
+				continue
+			}
+			current = append(current, matcher.FromSpotifyTrack(*track))
+			if isrc := track.ExternalIDs["isrc"]; isrc != "" {
+				currentISRCs[isrc] = true
+			}
+		}
+	}
+
+	var trackIDs []spotifyPkg.ID
+	queued := make(map[spotifyPkg.ID]bool)
+	var matched, missing []float64
+	var missingTracks []tidal.Track
+	for _, track := range tidalPlaylist.Tracks {
+		if ctx.Err() != nil {
+			return fmt.Errorf("import to Spotify cancelled: %w", ctx.Err())
+		}
+		source := matcher.FromTidalTrack(track)
+		// Check if track is already in the Spotify playlist
+		if source.ISRC != "" && currentISRCs[source.ISRC] {
+			log.Debug().Msgf("Track %s already in playlist %s", track.Title, playlist.Name)
+			playlistReport.Present++
+			matched = append(matched, 1)
+			if opts.Plan == nil {
+				metrics.Inc(metrics.TracksMatched, "target", "spotify", "strategy", "in_playlist")
+			}
+			continue
+		}
+		if result, ok := playlistMatcher.Best(source, current); ok {
+			log.Debug().Msgf("Track %s already in playlist %s", track.Title, playlist.Name)
+			playlistReport.Present++
+			matched = append(matched, result.Confidence)
+			if opts.Plan == nil {
+				metrics.Inc(metrics.TracksMatched, "target", "spotify", "strategy", "in_playlist")
+			}
+			continue
+		}
+		// Search for track on Spotify
+		result, strategy, ok, err := searchSpotify(ctx, spotifyService, playlistMatcher, source)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error searching for track %s", track.Title)
+			playlistReport.Errors = append(playlistReport.Errors, fmt.Sprintf("error searching for track %s: %s", track.Title, err))
+			continue
+		}
+		if !ok {
+			log.Debug().Msgf("Track %s not found on Spotify", track.Title)
+			playlistReport.Missing++
+			if result.Candidate.ID != "" {
+				missing = append(missing, result.Confidence)
+			}
+			missingTracks = append(missingTracks, track)
+			if opts.Plan == nil {
+				metrics.Inc(metrics.TracksMissing, "target", "spotify")
+			}
+			continue
+		}
+		log.Debug().Msgf("Found matching track %s on Spotify with confidence %.2f", track.Title, result.Confidence)
+		playlistReport.Added++
+		matched = append(matched, result.Confidence)
+		if opts.Plan == nil {
+			metrics.Inc(metrics.TracksMatched, "target", "spotify", "strategy", strategy)
+		}
+		// Queue track to be added to the Spotify playlist
+		id := spotifyPkg.ID(result.Candidate.ID)
+		if !queued[id] {
+			queued[id] = true
+			trackIDs = append(trackIDs, id)
+		}
+	}
+
+	// Add matched tracks to the Spotify playlist
+	if len(trackIDs) > 0 {
+		log.Info().Msgf("Adding %d tracks to playlist %s", len(trackIDs), playlist.Name)
+		err := spotifyService.AddTracksToPlaylist(ctx, playlist, trackIDs)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error adding tracks to playlist %s", playlist.Name)
+			playlistReport.Errors = append(playlistReport.Errors, fmt.Sprintf("error adding tracks: %s", err))
+			playlistReport.Failed, playlistReport.Added = playlistReport.Added, 0
+		}
+	}
+	// Missing tracks
+	if len(missingTracks) > 0 {
+		log.Info().Msgf("Found %d missing tracks", len(missingTracks))
+		err := file.ProcessMissingSpotifyTracks(missingTracks, tidalPlaylist.Title)
+		if err != nil {
+			return fmt.Errorf("error processing missing tracks: %w", err)
+		}
+	}
+	playlistReport.Matched = playlistReport.Added + playlistReport.Present + playlistReport.Failed
+	playlistReport.Confidence = report.Histogram(matched)
+	playlistReport.MissingConfidence = report.Histogram(missing)
+	opts.Report.AddPlaylist(playlistReport)
+	log.Info().Msgf("Finished importing playlist %s to Spotify", tidalPlaylist.Title)
+	if opts.Plan == nil {
+		metrics.Inc(metrics.PlaylistsProcessed, "command", "spotify import")
+	}
+	return nil
+}
+
+// searchSpotify finds the Spotify track of a Tidal track, by ISRC first and by title and artist otherwise.
+// It returns the best candidate, the strategy that found it and whether it is good enough.
+func searchSpotify(ctx context.Context, spotifyService *spotify.Service, playlistMatcher *matcher.Matcher, source matcher.Track) (matcher.Result, string, bool, error) {
+	if source.ISRC != "" {
+		tracks, err := spotifyService.SearchTracks(ctx, "isrc:"+source.ISRC)
+		if err != nil {
+			return matcher.Result{}, "", false, err
+		}
+		if result, ok := playlistMatcher.Best(source, fromSpotifyTracks(tracks)); ok {
+			return result, "isrc", true, nil
+		}
+	}
+	// Quotes can't be escaped in Spotify queries
+	query := fmt.Sprintf("track:\"%s\"", strings.ReplaceAll(source.Title, "\"", ""))
+	if len(source.Artists) > 0 {
+		query = fmt.Sprintf("%s artist:\"%s\"", query, strings.ReplaceAll(source.Artists[0], "\"", ""))
+	}
+	tracks, err := spotifyService.SearchTracks(ctx, query)
+	if err != nil {
+		return matcher.Result{}, "", false, err
+	}
+	result, ok := playlistMatcher.Best(source, fromSpotifyTracks(tracks))
+	return result, "search", ok, nil
+}
+
+func fromSpotifyTracks(tracks []spotifyPkg.FullTrack) []matcher.Track {
+	candidates := make([]matcher.Track, 0, len(tracks))
+	for _, track := range tracks {
+		candidates = append(candidates, matcher.FromSpotifyTrack(track))
+	}
+	return candidates
+}
@@ -0,0 +1,328 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"github.com/zibbp/music-utils/internal/cache"
+	"github.com/zibbp/music-utils/internal/explain"
+	"github.com/zibbp/music-utils/internal/file"
+	"github.com/zibbp/music-utils/internal/metrics"
+	"github.com/zibbp/music-utils/internal/overrides"
+	"github.com/zibbp/music-utils/internal/plan"
+	"github.com/zibbp/music-utils/internal/report"
+	"github.com/zibbp/music-utils/internal/spotify"
+	"github.com/zibbp/music-utils/internal/tidal"
+	"github.com/zibbp/music-utils/internal/utils"
+	spotifyPkg "github.com/zmb3/spotify/v2"
+)
+
+// ImportTidal imports the saved Spotify playlists to Tidal, creating missing playlists.
+func ImportTidal(ctx context.Context, opts Options) error {
+	tidalService, err := tidal.InitializeService(ctx, !opts.Unattended)
+	if err != nil {
+		return fmt.Errorf("error initializing tidal service: %w", err)
+	}
+	tidalService.Plan = opts.Plan
+	// Read local Spotify playlists from files
+	spotifyPlaylists, err := file.ReadUsersPlaylists()
+	if err != nil {
+		return fmt.Errorf("error reading users playlists: %w", err)
+	}
+	if len(spotifyPlaylists) == 0 {
+		return errors.New("no Spotify playlists found")
+	}
+	spotifyPlaylists, err = filterSpotifyPlaylists(ctx, opts, spotifyPlaylists)
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("Found %d Spotify playlists to process", len(spotifyPlaylists))
+	tidalPlaylists, err := tidalService.GetUserPlaylists(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting user tidal playlists: %w", err)
+	}
+	log.Info().Msgf("Found %d Tidal playlists", len(tidalPlaylists.Items))
+
+	search := utils.TidalSearch{Service: tidalService, Matcher: newMatcher()}
+	if viper.GetBool("cache.enabled") {
+		search.Cache, err = cache.Open("/data/cache.db", time.Duration(viper.GetInt("cache.ttl_hours"))*time.Hour, time.Duration(viper.GetInt("cache.negative_ttl_hours"))*time.Hour)
+		if err != nil {
+			return fmt.Errorf("error opening match cache: %w", err)
+		}
+		defer search.Cache.Close()
+		search.Cache.ReadOnly = opts.Plan != nil
+		search.Cache.Matcher = search.Matcher.Fingerprint()
+	}
+	search.Overrides, err = overrides.Load(overrides.Path)
+	if err != nil {
+		return fmt.Errorf("error loading overrides: %w", err)
+	}
+
+	var playlists Playlists
+	// Check if Spotify playlists exists on Tidal
+	for _, spotifyPlaylist := range spotifyPlaylists {
+		var playlist Playlist
+		onTidal, i := utils.SpotifyPlaylistOnTidal(spotifyPlaylist.Name, tidalPlaylists.Items)
+		uuid := ""
+		if !onTidal {
+			log.Info().Msgf("Playlist %s not found on Tidal", spotifyPlaylist.Name)
+			// Create playlist on Tidal
+			tidalPlaylist, err := tidalService.CreatePlaylist(ctx, spotifyPlaylist.Name, spotifyPlaylist.Description)
+			if err != nil {
+				log.Error().Err(err).Msgf("Error creating playlist %s on Tidal", spotifyPlaylist.Name)
+				opts.Report.AddError("error creating playlist %s on Tidal: %s", spotifyPlaylist.Name, err)
+				continue
+			}
+			log.Info().Msgf("Created playlist %s on Tidal", tidalPlaylist.Title)
+			uuid = tidalPlaylist.UUID
+		} else {
+			log.Debug().Msgf("Playlist %s found on Tidal", spotifyPlaylist.Name)
+			uuid = tidalPlaylists.Items[i].UUID
+		}
+		// Fetch full Tidal playlist
+		fullTidalPlaylist, err := tidalService.GetPlaylist(ctx, uuid)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error fetching playlist %s from Tidal", spotifyPlaylist.Name)
+			opts.Report.AddError("error fetching playlist %s from Tidal: %s", spotifyPlaylist.Name, err)
+			continue
+		}
+		playlist.Tidal = fullTidalPlaylist
+		playlist.Spotify = spotifyPlaylist
+		playlists.Playlists = append(playlists.Playlists, playlist)
+	}
+
+	// Spotify to Tidal import
+	for _, playlist := range playlists.Playlists {
+		err := importPlaylist(ctx, opts, search, playlist)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filterSpotifyPlaylists keeps the playlists allowed by the filter. Owned only filters log in to Spotify to get the user.
+func filterSpotifyPlaylists(ctx context.Context, opts Options, playlists []spotifyPkg.FullPlaylist) ([]spotifyPkg.FullPlaylist, error) {
+	playlistFilter := opts.Filter
+	if playlistFilter == nil {
+		return playlists, nil
+	}
+	var userID string
+	if playlistFilter.OwnedOnly {
+		spotifyService, err := spotify.InitializeService(ctx, !opts.Unattended)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing spotify service: %w", err)
+		}
+		userID, err = spotifyService.CurrentUserID()
+		if err != nil {
+			return nil, fmt.Errorf("error getting current spotify user: %w", err)
+		}
+	}
+	var filtered []spotifyPkg.FullPlaylist
+	for _, playlist := range playlists {
+		if !playlistFilter.Spotify(playlist.Name, playlist.Owner.ID, userID) {
+			log.Debug().Msgf("Skipping playlist %s as it is filtered out", playlist.Name)
+			continue
+		}
+		filtered = append(filtered, playlist)
+	}
+	log.Info().Msgf("%d of %d Spotify playlists match the playlist filter", len(filtered), len(playlists))
+	return filtered, nil
+}
+
+// importPlaylist adds the tracks of a Spotify playlist to its Tidal playlist.
+func importPlaylist(ctx context.Context, opts Options, search utils.TidalSearch, playlist Playlist) error {
+	tidalService := search.Service
+	log.Info().Msgf("Importing playlist %s to Tidal", playlist.Spotify.Name)
+	// Get all Tidal tracks
+	tidalPlaylistTracks, err := tidalService.GetPlaylistTracks(ctx, playlist.Tidal.UUID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error getting playlist tracks for %s", playlist.Tidal.Title)
+	}
+	playlistImport := utils.PlaylistImport{Playlist: playlist.Tidal, Tracks: tidalPlaylistTracks}
+	if err != nil {
+		playlistImport.Errors = append(playlistImport.Errors, fmt.Sprintf("error getting playlist tracks: %s", err))
+	}
+	if opts.Explain && opts.Plan != nil {
+		opts.Plan.Record(plan.ActionWriteFile, file.ExplainPath(playlist.Spotify.Name))
+	} else if opts.Explain {
+		search.Explain, err = explain.Create(file.ExplainPath(playlist.Spotify.Name))
+		if err != nil {
+			log.Error().Err(err).Msg("Error creating explain file")
+		}
+	}
+	// Check if tracks exist on Tidal
+	for _, spotifyTrack := range playlist.Spotify.Tracks.Tracks {
+		if ctx.Err() != nil {
+			search.Explain.Close()
+			return fmt.Errorf("import to Tidal cancelled: %w", ctx.Err())
+		}
+		// Spotify edge case if track is missing
+		if spotifyTrack.Track.ID == "" {
+			log.Debug().Msgf("Track %s is missing ID", spotifyTrack.Track.Name)
+			utils.SkipTrack(spotifyTrack, &playlistImport)
+			continue
+		}
+		utils.SpotifyToTidalSearch(ctx, search, spotifyTrack, &playlistImport)
+	}
+	err = search.Explain.Close()
+	if err != nil {
+		log.Error().Err(err).Msg("Error closing explain file")
+	}
+	// Tracks whose lookup failed keep their previous match
+	previousTracks, previousErr := file.ReadPlaylistMatchedTracks(playlist.Spotify.Name)
+	if previousErr != nil {
+		log.Error().Err(previousErr).Msgf("Error reading previous matches for %s", playlist.Spotify.Name)
+	}
+	utils.KeepPreviousMatches(playlistImport.Matched, previousTracks)
+	// Add matched tracks to the Tidal playlist
+	if len(playlistImport.TrackIds) > 0 {
+		log.Info().Msgf("Adding %d tracks to playlist %s", len(playlistImport.TrackIds), playlist.Tidal.Title)
+		err = tidalService.AddTracksToPlaylist(ctx, playlist.Tidal.UUID, playlistImport.TrackIds)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error adding tracks to playlist %s", playlist.Tidal.Title)
+			playlistImport.Errors = append(playlistImport.Errors, fmt.Sprintf("error adding tracks: %s", err))
+		}
+	}
+	// Missing tracks
+	if len(playlistImport.Missing) > 0 {
+		log.Info().Msgf("Found %d missing tracks", len(playlistImport.Missing))
+		err := file.ProcessMissingTracks(playlistImport.Missing, playlist.Spotify.Name)
+		if err != nil {
+			return fmt.Errorf("error processing missing tracks: %w", err)
+		}
+	}
+	// Fetch Tidal playlist and write to file
+	tidalPlaylistTracks, err = tidalService.GetPlaylistTracks(ctx, playlist.Tidal.UUID)
+	if err != nil {
+		return fmt.Errorf("error getting playlist tracks for %s: %w", playlist.Tidal.Title, err)
+	}
+	// Remove tracks that were removed from the Spotify playlist
+	removed := 0
+	trackIds, complete := utils.SyncSource(playlistImport.Matched, previousTracks)
+	if opts.DeleteRemoved && (previousErr != nil || !complete) {
+		log.Warn().Msgf("Not removing tracks from playlist %s as some tracks could not be looked up", playlist.Tidal.Title)
+	} else if opts.DeleteRemoved {
+		removed, err = utils.RemoveTidalTracks(ctx, tidalService, playlist.Tidal, tidalPlaylistTracks, trackIds)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error removing tracks from playlist %s", playlist.Tidal.Title)
+			playlistImport.Errors = append(playlistImport.Errors, fmt.Sprintf("error removing tracks: %s", err))
+		}
+		// A dry run leaves the playlist as it is
+		if removed > 0 && opts.Plan == nil {
+			tidalPlaylistTracks, err = tidalService.GetPlaylistTracks(ctx, playlist.Tidal.UUID)
+			if err != nil {
+				return fmt.Errorf("error getting playlist tracks for %s: %w", playlist.Tidal.Title, err)
+			}
+		}
+	}
+	// Match the order of the Spotify playlist
+	if viper.GetBool("tidal.preserve_order") {
+		moves, err := tidalService.ReorderPlaylist(ctx, playlist.Tidal.UUID, tidalPlaylistTracks.Items, utils.TidalOrder(playlistImport.Matched))
+		if err != nil {
+			log.Error().Err(err).Msgf("Error reordering playlist %s", playlist.Tidal.Title)
+			playlistImport.Errors = append(playlistImport.Errors, fmt.Sprintf("error reordering playlist: %s", err))
+		}
+		if moves > 0 {
+			log.Info().Msgf("Moved %d tracks in playlist %s", moves, playlist.Tidal.Title)
+			tidalPlaylistTracks, err = tidalService.GetPlaylistTracks(ctx, playlist.Tidal.UUID)
+			if err != nil {
+				return fmt.Errorf("error getting playlist tracks for %s: %w", playlist.Tidal.Title, err)
+			}
+		}
+	}
+	playlist.Tidal.Tracks = tidalPlaylistTracks.Items
+	// Matched tracks with their confidence
+	// A dry run only plans the adds, so they are never in the playlist
+	if opts.Plan == nil {
+		utils.MarkFailedTracks(playlistImport.Matched, tidalPlaylistTracks)
+	}
+	err = file.WriteMatchedTracks(playlistImport.Matched, playlist.Spotify.Name)
+	if err != nil {
+		log.Error().Err(err).Msg("Error writing matched tracks")
+	}
+	// Write to file
+	err = file.WriteTidalPlaylistToFile(playlist.Tidal)
+	if err != nil {
+		return fmt.Errorf("error writing tidal playlist to file: %w", err)
+	}
+	playlistReport := report.MatchedPlaylist(playlist.Spotify.Name, report.TargetTidal, playlistImport.Matched)
+	playlistReport.Source = len(playlist.Spotify.Tracks.Tracks)
+	playlistReport.Removed = removed
+	playlistReport.Errors = playlistImport.Errors
+	opts.Report.AddPlaylist(playlistReport)
+	log.Info().Msgf("Finished importing playlist %s to Tidal", playlist.Spotify.Name)
+	// A dry run changes nothing, so it is not counted
+	if opts.Plan == nil {
+		metrics.Inc(metrics.PlaylistsProcessed, "command", "tidal import")
+	}
+	return nil
+}
+
+// SaveTidal saves the Tidal playlists listed in playlists.txt to files.
+func SaveTidal(ctx context.Context, opts Options) error {
+	tidalService, err := tidal.InitializeService(ctx, !opts.Unattended)
+	if err != nil {
+		return fmt.Errorf("error initializing tidal service: %w", err)
+	}
+	tidalService.Plan = opts.Plan
+	log.Info().Msg("Saving Tidal playlists to file")
+	playlistUrls, err := file.ReadTidalPlaylistsToSave()
+	if err != nil {
+		return fmt.Errorf("error reading tidal playlists to save: %w", err)
+	}
+	for _, playlistUrl := range playlistUrls {
+		// Extract uuid from url
+		uuid := utils.ExtractUUID(playlistUrl)
+		if uuid == "" {
+			log.Error().Msgf("Error extracting uuid from %s", playlistUrl)
+			opts.Report.AddError("error extracting uuid from %s", playlistUrl)
+			continue
+		}
+		// Get playlist
+		tidalPlaylist, err := tidalService.GetPlaylist(ctx, uuid)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error getting playlist %s from Tidal", uuid)
+			opts.Report.AddError("error getting playlist %s from Tidal: %s", uuid, err)
+			continue
+		}
+		// Get playlist tracks
+		tidalPlaylistTracks, err := tidalService.GetPlaylistTracks(ctx, uuid)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error getting playlist tracks for %s", tidalPlaylist.Title)
+			opts.Report.AddError("error getting playlist tracks for %s: %s", tidalPlaylist.Title, err)
+			continue
+		}
+		tidalPlaylist.Tracks = tidalPlaylistTracks.Items
+
+		// Write to file
+		err = file.WriteTidalPlaylistToFile(tidalPlaylist)
+		if err != nil {
+			return fmt.Errorf("error writing tidal playlist to file: %w", err)
+		}
+		opts.Report.AddPlaylist(report.Playlist{Name: tidalPlaylist.Title, Target: report.TargetTidal, Source: len(tidalPlaylist.Tracks)})
+		log.Info().Msgf("Finished saving playlist %s to file", tidalPlaylist.Title)
+		if opts.Plan == nil {
+			metrics.Inc(metrics.PlaylistsProcessed, "command", "tidal save")
+		}
+	}
+	return nil
+}
+
+// AuthTidal logs in to Tidal, or checks the saved session. With force the saved tokens are discarded first.
+func AuthTidal(ctx context.Context, force bool) error {
+	if force {
+		viper.Set("tidal.access_token", "")
+		viper.Set("tidal.refresh_token", "")
+	}
+	_, err := tidal.InitializeService(ctx, true)
+	if err != nil {
+		return fmt.Errorf("error initializing tidal service: %w", err)
+	}
+	log.Info().Msg("Tidal is authorized")
+	return nil
+}
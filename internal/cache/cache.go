@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog/log"
+)
+
+// Cache stores resolved Spotify to Tidal matches so re-runs don't search Tidal again.
+// A nil *Cache is valid and behaves as an always empty cache.
+type Cache struct {
+	DB          *sql.DB
+	TTL         time.Duration
+	NegativeTTL time.Duration
+	// ReadOnly skips writes, for dry runs
+	ReadOnly bool
+	// Matcher is the fingerprint of the matcher, entries stored by another matcher are ignored
+	Matcher string
+}
+
+// Entry is a cached match. A zero TidalID is a cached negative result.
+type Entry struct {
+	TidalID      int64
+	TidalTitle   string
+	TidalArtists []string
+	Confidence   float64
+	UpdatedAt    time.Time
+}
+
+func (e Entry) Found() bool {
+	return e.TidalID != 0
+}
+
+func Open(path string, ttl, negativeTTL time.Duration) (*Cache, error) {
+	log.Debug().Msgf("Opening match cache %s", path)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening match cache: %w", err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS tidal_matches (
+		source_key TEXT PRIMARY KEY,
+		tidal_id INTEGER NOT NULL,
+		tidal_title TEXT NOT NULL,
+		tidal_artists TEXT NOT NULL,
+		confidence REAL NOT NULL,
+		updated_at INTEGER NOT NULL,
+		matcher TEXT NOT NULL DEFAULT ''
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating match cache table: %w", err)
+	}
+	err = addMatcherColumn(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Cache{DB: db, TTL: ttl, NegativeTTL: negativeTTL}, nil
+}
+
+// addMatcherColumn adds the matcher column to caches created before it existed. Their entries
+// have no matcher and are searched again.
+func addMatcherColumn(db *sql.DB) error {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('tidal_matches') WHERE name = 'matcher'").Scan(&count)
+	if err != nil {
+		return fmt.Errorf("error reading match cache table: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err = db.Exec("ALTER TABLE tidal_matches ADD COLUMN matcher TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return fmt.Errorf("error updating match cache table: %w", err)
+	}
+	return nil
+}
+
+func (c *Cache) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.DB.Close()
+}
+
+func SpotifyKey(id string) string {
+	return "spotify:" + id
+}
+
+func ISRCKey(isrc string) string {
+	return "isrc:" + strings.ToUpper(isrc)
+}
+
+// Get returns the first unexpired entry stored by the same matcher found for the given keys.
+func (c *Cache) Get(keys ...string) (Entry, bool) {
+	if c == nil {
+		return Entry{}, false
+	}
+	for _, key := range keys {
+		var entry Entry
+		var artists string
+		var updatedAt int64
+		var matcher string
+		err := c.DB.QueryRow("SELECT tidal_id, tidal_title, tidal_artists, confidence, updated_at, matcher FROM tidal_matches WHERE source_key = ?", key).
+			Scan(&entry.TidalID, &entry.TidalTitle, &artists, &entry.Confidence, &updatedAt, &matcher)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Error().Err(err).Msgf("Error reading match cache for %s", key)
+			}
+			continue
+		}
+		if matcher != c.Matcher {
+			log.Debug().Msgf("Match cache entry %s was stored by another matcher", key)
+			continue
+		}
+		entry.UpdatedAt = time.Unix(updatedAt, 0)
+		if artists != "" {
+			entry.TidalArtists = strings.Split(artists, "\x1f")
+		}
+		ttl := c.TTL
+		if !entry.Found() {
+			ttl = c.NegativeTTL
+		}
+		if ttl > 0 && time.Since(entry.UpdatedAt) > ttl {
+			log.Debug().Msgf("Match cache entry %s expired", key)
+			continue
+		}
+		return entry, true
+	}
+	return Entry{}, false
+}
+
+// Put stores the entry under every given key, empty keys are skipped.
+func (c *Cache) Put(entry Entry, keys ...string) error {
+	if c == nil || c.ReadOnly {
+		return nil
+	}
+	if entry.UpdatedAt.IsZero() {
+		entry.UpdatedAt = time.Now()
+	}
+	for _, key := range keys {
+		if key == "" || strings.HasSuffix(key, ":") {
+			continue
+		}
+		_, err := c.DB.Exec("INSERT OR REPLACE INTO tidal_matches (source_key, tidal_id, tidal_title, tidal_artists, confidence, updated_at, matcher) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			key, entry.TidalID, entry.TidalTitle, strings.Join(entry.TidalArtists, "\x1f"), entry.Confidence, entry.UpdatedAt.Unix(), c.Matcher)
+		if err != nil {
+			return fmt.Errorf("error writing match cache: %w", err)
+		}
+	}
+	return nil
+}
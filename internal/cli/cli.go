@@ -0,0 +1,382 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog/pkgerrors"
+	"github.com/spf13/viper"
+	"github.com/zibbp/music-utils/internal/app"
+	"github.com/zibbp/music-utils/internal/config"
+	"github.com/zibbp/music-utils/internal/file"
+	"github.com/zibbp/music-utils/internal/filter"
+	"github.com/zibbp/music-utils/internal/metrics"
+	"github.com/zibbp/music-utils/internal/notification"
+	"github.com/zibbp/music-utils/internal/plan"
+	"github.com/zibbp/music-utils/internal/report"
+)
+
+const name = "music-utils"
+
+// Exit codes
+const (
+	ExitOK          = 0
+	ExitError       = 1
+	ExitUsage       = 2
+	ExitInterrupted = 130
+)
+
+// command is a subcommand such as "tidal import". Commands without a name are run by their group alone.
+type command struct {
+	group   string
+	name    string
+	summary string
+	// done describes a successful run in notifications
+	done string
+	// changes is set for commands that change playlists or files, they get the dry-run flags
+	changes bool
+	// playlists is set for commands that handle a list of playlists, they get the filter flags
+	playlists bool
+	// flags registers the flags of the command
+	flags func(fs *flag.FlagSet, opts *options)
+	run   func(ctx context.Context, opts options) error
+}
+
+// options are the parsed flags of a command
+type options struct {
+	app.Options
+	dryRun        bool
+	dryRunJSON    bool
+	notify        bool
+	force         bool
+	include       stringList
+	exclude       stringList
+	spotifyOwners stringList
+	tidalCreators stringList
+	ownedOnly     bool
+	in            io.Reader
+	out           io.Writer
+}
+
+var commands = []command{
+	{
+		group:     "spotify",
+		name:      "save",
+		summary:   "Save Spotify playlists to files",
+		done:      "saved Spotify playlists",
+		changes:   true,
+		playlists: true,
+		run: func(ctx context.Context, opts options) error {
+			return app.SaveSpotify(ctx, opts.Options)
+		},
+	},
+	{
+		group:     "spotify",
+		name:      "import",
+		summary:   "Import the saved Tidal playlists to Spotify",
+		done:      "imported playlists to Spotify",
+		changes:   true,
+		playlists: true,
+		run: func(ctx context.Context, opts options) error {
+			return app.ImportSpotify(ctx, opts.Options)
+		},
+	},
+	{
+		group:     "tidal",
+		name:      "import",
+		summary:   "Import the saved Spotify playlists to Tidal",
+		done:      "imported playlists to Tidal",
+		changes:   true,
+		playlists: true,
+		flags: func(fs *flag.FlagSet, opts *options) {
+			fs.BoolVar(&opts.Explain, "explain", false, "Write a trace of why each Tidal candidate was accepted or rejected")
+			fs.BoolVar(&opts.DeleteRemoved, "delete-removed", false, "Remove tracks from Tidal playlists that were removed from the Spotify playlist")
+		},
+		run: func(ctx context.Context, opts options) error {
+			return app.ImportTidal(ctx, opts.Options)
+		},
+	},
+	{
+		group:   "tidal",
+		name:    "save",
+		summary: "Save the Tidal playlists listed in data/tidal/playlists.txt to files",
+		done:    "saved Tidal playlists",
+		changes: true,
+		run: func(ctx context.Context, opts options) error {
+			return app.SaveTidal(ctx, opts.Options)
+		},
+	},
+	{
+		group:     "navidrome",
+		name:      "export",
+		summary:   "Generate Navidrome playlist files from the saved Tidal playlists using Navidrome's database",
+		done:      "generated Navidrome playlist files",
+		changes:   true,
+		playlists: true,
+		flags: func(fs *flag.FlagSet, opts *options) {
+			fs.BoolVar(&opts.DeleteRemoved, "delete-removed", false, "Remove tracks from playlist files that were removed from the Tidal playlist")
+		},
+		run: func(ctx context.Context, opts options) error {
+			return app.ExportNavidrome(ctx, opts.Options)
+		},
+	},
+	{
+		group:   "lidarr",
+		name:    "wanted",
+		summary: "Find wanted Lidarr albums on Tidal and save the links to a file",
+		done:    "processed wanted Lidarr albums",
+		changes: true,
+		run: func(ctx context.Context, opts options) error {
+			return app.LidarrWanted(ctx, opts.Options)
+		},
+	},
+	{
+		group:   "review",
+		summary: "Interactively review missing and low confidence matches",
+		changes: true,
+		run: func(ctx context.Context, opts options) error {
+			return app.Review(ctx, opts.Options, opts.in, opts.out)
+		},
+	},
+	{
+		group:   "serve",
+		summary: "Run the configured jobs on their schedules until stopped",
+		run: func(ctx context.Context, opts options) error {
+			return serve(ctx)
+		},
+	},
+	{
+		group:   "auth",
+		name:    "tidal",
+		summary: "Log in to Tidal, or check the saved session",
+		flags: func(fs *flag.FlagSet, opts *options) {
+			fs.BoolVar(&opts.force, "force", false, "Discard the saved tokens and log in again")
+		},
+		run: func(ctx context.Context, opts options) error {
+			return app.AuthTidal(ctx, opts.force)
+		},
+	},
+	{
+		group:   "auth",
+		name:    "spotify",
+		summary: "Log in to Spotify, or check the saved session",
+		flags: func(fs *flag.FlagSet, opts *options) {
+			fs.BoolVar(&opts.force, "force", false, "Discard the saved tokens and log in again")
+		},
+		run: func(ctx context.Context, opts options) error {
+			return app.AuthSpotify(ctx, opts.force)
+		},
+	},
+}
+
+// Run runs the subcommand in args and returns the exit code.
+func Run(ctx context.Context, args []string, in io.Reader, out io.Writer, errOut io.Writer) int {
+	if len(args) == 0 {
+		usage(errOut)
+		return ExitUsage
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		usage(out)
+		return ExitOK
+	}
+
+	cmd, rest, ok := find(args)
+	if !ok {
+		fmt.Fprintf(errOut, "unknown command %q\n\n", strings.Join(args[:min(2, len(args))], " "))
+		usage(errOut)
+		return ExitUsage
+	}
+
+	opts := options{in: in, out: out}
+	fs := flag.NewFlagSet(cmd.title(), flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags]\n\n%s\n\nFlags:\n", cmd.title(), cmd.summary)
+		fs.PrintDefaults()
+	}
+	if cmd.changes {
+		fs.BoolVar(&opts.dryRun, "dry-run", false, "Print the planned changes instead of changing playlists and files")
+		fs.BoolVar(&opts.dryRunJSON, "dry-run-json", false, "Print the dry-run plan as JSON")
+	}
+	if cmd.done != "" {
+		fs.BoolVar(&opts.notify, "notify", false, "Send a notification to the notification targets when done or failed")
+		fs.BoolVar(&opts.notify, "notify-webhook", false, "Alias of -notify")
+	}
+	if cmd.playlists {
+		fs.Var(&opts.include, "include", "Only handle playlists matching this `pattern`: a name, glob:pattern or regex:pattern, can be repeated")
+		fs.Var(&opts.exclude, "exclude", "Skip playlists matching this `pattern`: a name, glob:pattern or regex:pattern, can be repeated")
+		fs.Var(&opts.spotifyOwners, "spotify-owner", "Only handle Spotify playlists owned by this user `id`, can be repeated")
+		fs.Var(&opts.tidalCreators, "tidal-creator", "Only handle Tidal playlists created by this user `id`, can be repeated")
+		fs.BoolVar(&opts.ownedOnly, "owned-only", false, "Only handle playlists owned by you")
+	}
+	if cmd.flags != nil {
+		cmd.flags(fs, &opts)
+	}
+	err := fs.Parse(rest)
+	if errors.Is(err, flag.ErrHelp) {
+		return ExitOK
+	}
+	if err != nil {
+		return ExitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(errOut, "unexpected arguments: %s\n\n", strings.Join(fs.Args(), " "))
+		fs.Usage()
+		return ExitUsage
+	}
+
+	err = setup()
+	if err != nil {
+		log.Error().Err(err).Msg("Error setting up")
+		return ExitError
+	}
+
+	// Broken notification targets are reported before the run, not after it
+	if opts.notify {
+		_, err = app.NotificationTargets()
+		if err != nil {
+			log.Error().Err(err).Msg("Error reading notification targets")
+			return ExitUsage
+		}
+	}
+
+	opts.DeleteRemoved = opts.DeleteRemoved || viper.GetBool("sync.delete_removed")
+	if cmd.playlists {
+		opts.Filter, err = filter.New(
+			append(viper.GetStringSlice("playlists.include"), opts.include...),
+			append(viper.GetStringSlice("playlists.exclude"), opts.exclude...),
+			append(viper.GetStringSlice("playlists.spotify_owners"), opts.spotifyOwners...),
+			append(viper.GetStringSlice("playlists.tidal_creators"), opts.tidalCreators...),
+			opts.ownedOnly || viper.GetBool("playlists.owned_only"),
+		)
+		if err != nil {
+			log.Error().Err(err).Msg("Error reading playlist filters")
+			return ExitUsage
+		}
+	}
+	if opts.dryRun || opts.dryRunJSON {
+		log.Info().Msg("dry-run enabled, no changes will be made")
+		opts.Plan = plan.New()
+		file.SetPlan(opts.Plan)
+	}
+
+	if _, ok := app.Commands[cmd.path()]; ok {
+		opts.Report = report.New(cmd.path(), opts.Plan != nil)
+	}
+
+	started := time.Now()
+	err = cmd.run(ctx, opts)
+	metrics.ObserveRun(cmd.path(), started, err)
+	app.SaveReport(opts.Report, err)
+	if path := viper.GetString("metrics.textfile"); path != "" && opts.Plan == nil {
+		textfileErr := metrics.WriteTextfile(path)
+		if textfileErr != nil {
+			log.Error().Err(textfileErr).Msg("Error writing metrics")
+		}
+	}
+	if opts.notify {
+		// An interrupted run still notifies, so the request gets a context of its own
+		notifyErr := app.Notify(context.WithoutCancel(ctx), opts.Options, notification.NewEvent(cmd.path(), cmd.done, started, err, opts.Report))
+		if notifyErr != nil {
+			log.Error().Err(notifyErr).Msg("Error sending notification")
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("%s failed", cmd.title())
+		if ctx.Err() != nil {
+			return ExitInterrupted
+		}
+		return ExitError
+	}
+
+	// Print the plan of a dry run
+	if opts.Plan != nil {
+		if opts.dryRunJSON {
+			err = opts.Plan.JSON(out)
+		} else {
+			err = opts.Plan.Summary(out)
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Error printing dry-run plan")
+			return ExitError
+		}
+	}
+	return ExitOK
+}
+
+// stringList is a flag that can be given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func (c command) title() string {
+	return fmt.Sprintf("%s %s", name, c.path())
+}
+
+// path is the command without the program name, such as "tidal import"
+func (c command) path() string {
+	if c.name == "" {
+		return c.group
+	}
+	return fmt.Sprintf("%s %s", c.group, c.name)
+}
+
+// find returns the command named by args and the arguments left for its flags.
+func find(args []string) (command, []string, bool) {
+	for _, cmd := range commands {
+		if cmd.group != args[0] {
+			continue
+		}
+		if cmd.name == "" {
+			return cmd, args[1:], true
+		}
+		if len(args) > 1 && cmd.name == args[1] {
+			return cmd, args[2:], true
+		}
+	}
+	return command{}, nil, false
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags]\n\nCommands:\n", name)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-18s %s\n", cmd.path(), cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of a command.\n", name)
+}
+
+// setup loads the config, creates the data folders and configures logging.
+func setup() error {
+	err := config.Initialize()
+	if err != nil {
+		return fmt.Errorf("error initializing config: %w", err)
+	}
+
+	err = file.Initialize()
+	if err != nil {
+		return fmt.Errorf("error initializing file service: %w", err)
+	}
+
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	if viper.GetBool("debug") {
+		log.Info().Msg("debug mode enabled")
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"github.com/zibbp/music-utils/internal/api"
+	"github.com/zibbp/music-utils/internal/daemon"
+	"github.com/zibbp/music-utils/internal/metrics"
+	"github.com/zibbp/music-utils/internal/spotify"
+)
+
+// serve runs the jobs from the config and the HTTP API until ctx is done.
+func serve(ctx context.Context) error {
+	var jobs []daemon.Job
+	err := viper.UnmarshalKey("serve.jobs", &jobs)
+	if err != nil {
+		return fmt.Errorf("error reading jobs: %w", err)
+	}
+	d, err := daemon.New(jobs, viper.GetInt("serve.history_size"))
+	if err != nil {
+		return err
+	}
+	d.SyncSteps = viper.GetStringSlice("serve.sync_steps")
+
+	mux := http.NewServeMux()
+	api.New(d, viper.GetString("serve.api_token"), viper.GetString("serve.address")).Register(mux)
+	spotify.RegisterCallback(mux)
+	mux.Handle("GET /metrics", metrics.Handler())
+	server := &http.Server{Addr: viper.GetString("serve.address"), Handler: mux}
+	go func() {
+		log.Info().Msgf("Serving the API on %s", server.Addr)
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("Error serving the API")
+		}
+	}()
+
+	err = d.Run(ctx)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdownErr := server.Shutdown(shutdownCtx)
+	if shutdownErr != nil {
+		log.Error().Err(shutdownErr).Msg("Error stopping the API")
+	}
+	return err
+}
@@ -15,18 +15,69 @@ import (
 type Config struct {
 	Debug   bool
 	Spotify struct {
-		ClientID     string
-		ClientSecret string
-		AccessToken  string
-		RefreshToken string
-		Expiry       time.Time
-		TokenType    string
-		RedirectURI  string
+		ClientID      string
+		ClientSecret  string
+		AccessToken   string
+		RefreshToken  string
+		Expiry        time.Time
+		TokenType     string
+		RedirectURI   string
+		SearchResults int
 	}
 	Tidal struct {
-		UserID       string
-		AccessToken  string
-		RefreshToken string
+		UserID            string
+		AccessToken       string
+		RefreshToken      string
+		PageSize          int
+		SearchResults     int
+		RequestsPerSecond float64
+		MaxRetries        int
+		TimeoutSeconds    int
+		AddChunkSize      int
+		PreserveOrder     bool
+	}
+	Sync struct {
+		DeleteRemoved bool
+	}
+	Metrics struct {
+		Textfile string
+	}
+	Reports struct {
+		Enabled bool
+		HTML    bool
+	}
+	Serve struct {
+		Address     string
+		APIToken    string
+		SyncSteps   []string
+		HistorySize int
+		Jobs        []struct {
+			Name          string
+			Schedule      string
+			Steps         []string
+			NotifyWebhook bool
+		}
+	}
+	Playlists struct {
+		Include       []string
+		Exclude       []string
+		SpotifyOwners []string
+		TidalCreators []string
+		OwnedOnly     bool
+	}
+	Matcher struct {
+		Threshold          float64
+		DurationTolerance  int64
+		ExplicitPreference string
+	}
+	Cache struct {
+		Enabled          bool
+		TTLHours         int
+		NegativeTTLHours int
+	}
+	Review struct {
+		Candidates int
+		Threshold  float64
 	}
 	Lidarr struct {
 		Host   string
@@ -34,7 +85,21 @@ type Config struct {
 	}
 	Notification struct {
 		Webhook struct {
-			URL string
+			URL          string
+			Template     string
+			TemplateFile string
+		}
+		Targets []struct {
+			Name         string
+			Type         string
+			URL          string
+			Token        string
+			Priority     int
+			Tags         []string
+			Events       []string
+			MissingAbove int
+			Template     string
+			TemplateFile string
 		}
 	}
 }
@@ -58,12 +123,49 @@ func Initialize() error {
 	viper.SetDefault("spotify.expiry", "")
 	viper.SetDefault("spotify.token_type", "")
 	viper.SetDefault("spotify.redirect_uri", "http://localhost:28542/callback")
+	viper.SetDefault("spotify.search_results", 20)
 	viper.SetDefault("tidal.user_id", "")
 	viper.SetDefault("tidal.access_token", "")
 	viper.SetDefault("tidal.refresh_token", "")
+	viper.SetDefault("tidal.page_size", 100)
+	viper.SetDefault("tidal.search_results", 50)
+	viper.SetDefault("tidal.requests_per_second", 5)
+	viper.SetDefault("tidal.max_retries", 5)
+	viper.SetDefault("tidal.timeout_seconds", 30)
+	viper.SetDefault("tidal.add_chunk_size", 100)
+	viper.SetDefault("tidal.preserve_order", false)
+	viper.SetDefault("sync.delete_removed", false)
+
+	viper.SetDefault("playlists.include", []string{})
+	viper.SetDefault("playlists.exclude", []string{})
+	viper.SetDefault("playlists.spotify_owners", []string{})
+	viper.SetDefault("playlists.tidal_creators", []string{})
+	viper.SetDefault("playlists.owned_only", false)
+
+	viper.SetDefault("metrics.textfile", "")
+
+	viper.SetDefault("reports.enabled", true)
+	viper.SetDefault("reports.html", false)
+
+	viper.SetDefault("serve.address", ":28542")
+	viper.SetDefault("serve.api_token", "")
+	viper.SetDefault("serve.history_size", 100)
+	viper.SetDefault("serve.sync_steps", []string{"spotify save", "tidal import", "navidrome export"})
+	viper.SetDefault("serve.jobs", []map[string]interface{}{})
+	viper.SetDefault("matcher.threshold", 0.75)
+	viper.SetDefault("matcher.duration_tolerance", 3)
+	viper.SetDefault("matcher.explicit_preference", "source")
+	viper.SetDefault("cache.enabled", true)
+	viper.SetDefault("cache.ttl_hours", 720)
+	viper.SetDefault("cache.negative_ttl_hours", 24)
+	viper.SetDefault("review.candidates", 5)
+	viper.SetDefault("review.threshold", 0.9)
 	viper.SetDefault("lidarr.host", "")
 	viper.SetDefault("lidarr.api_key", "")
 	viper.SetDefault("notification.webhook.url", "")
+	viper.SetDefault("notification.webhook.template", "")
+	viper.SetDefault("notification.webhook.template_file", "")
+	viper.SetDefault("notification.targets", []map[string]interface{}{})
 
 	viper.BindEnv("spotify.client_id", "SPOTIFY_CLIENT_ID")
 	viper.BindEnv("spotify.client_secret", "SPOTIFY_CLIENT_SECRET")
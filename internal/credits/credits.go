@@ -0,0 +1,100 @@
+package credits
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/zibbp/music-utils/internal/normalize"
+)
+
+var (
+	// Separates the primary artists from the featured ones in an artist string, e.g. "A feat. B"
+	featRegex = regexp.MustCompile(`(?i)\s+(?:feat\.?|ft\.?|featuring)\s+`)
+	// Credits in brackets in a title, e.g. "(feat. X & Y)", "[ft. X]" or "(with X)"
+	titleCreditRegex = regexp.MustCompile(`(?i)\s*[\(\[]\s*(?:feat\.?|ft\.?|featuring|with)\s+([^\)\]]+)[\)\]]`)
+	// Credits without brackets in a title, e.g. "Song feat. X", stops at the next bracket
+	titleInlineCreditRegex = regexp.MustCompile(`(?i)\s(?:feat\.?|ft\.?|featuring)\s+([^\(\[]+)`)
+	// Separates multiple artists, e.g. "A, B; C". "&" and "/" are part of names like "Simon & Garfunkel" and "AC/DC"
+	separatorRegex = regexp.MustCompile(`\s*[,;]\s*`)
+)
+
+// Credits are the artists credited on a track.
+type Credits struct {
+	Primary  []string
+	Featured []string
+}
+
+// All returns the primary followed by the featured artists.
+func (c Credits) All() []string {
+	return append(append([]string{}, c.Primary...), c.Featured...)
+}
+
+// ParseArtist splits an artist string like "A, B feat. C; D" into primary and featured artists.
+func ParseArtist(s string) Credits {
+	var c Credits
+	parts := featRegex.Split(s, 2)
+	c.Primary = split(parts[0])
+	if len(parts) > 1 {
+		c.Featured = split(parts[1])
+	}
+	return c
+}
+
+// Names returns the artists of a free-form artist string, like a Navidrome artist tag: the primary
+// credit as a whole and every artist listed in it. A name with a comma such as "Earth, Wind & Fire" is kept.
+func Names(s string) []string {
+	primary := strings.TrimSpace(featRegex.Split(s, 2)[0])
+	return append([]string{primary}, ParseArtist(s).All()...)
+}
+
+// ParseTitle removes featured artist credits from a title and returns them.
+func ParseTitle(title string) (string, []string) {
+	var featured []string
+	for _, match := range titleCreditRegex.FindAllStringSubmatch(title, -1) {
+		featured = append(featured, split(match[1])...)
+	}
+	title = titleCreditRegex.ReplaceAllString(title, "")
+	if match := titleInlineCreditRegex.FindStringSubmatch(title); match != nil {
+		featured = append(featured, split(match[1])...)
+		title = strings.Replace(title, match[0], " ", 1)
+	}
+	return strings.Join(strings.Fields(title), " "), featured
+}
+
+// Artists returns every artist credited in the artist names and the title, without duplicates.
+// Each artist name is a single artist as listed by the provider, only a featured credit in it is split off.
+func Artists(title string, artists []string) []string {
+	var all []string
+	seen := make(map[string]bool)
+	add := func(names []string) {
+		for _, name := range names {
+			key := normalize.Artist(name)
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			all = append(all, name)
+		}
+	}
+	for _, artist := range artists {
+		parts := featRegex.Split(artist, 2)
+		add([]string{strings.TrimSpace(parts[0])})
+		if len(parts) > 1 {
+			add(split(parts[1]))
+		}
+	}
+	_, featured := ParseTitle(title)
+	add(featured)
+	return all
+}
+
+func split(s string) []string {
+	var names []string
+	for _, name := range separatorRegex.Split(s, -1) {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,422 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"github.com/zibbp/music-utils/internal/app"
+	"github.com/zibbp/music-utils/internal/file"
+	"github.com/zibbp/music-utils/internal/metrics"
+	"github.com/zibbp/music-utils/internal/notification"
+	"github.com/zibbp/music-utils/internal/report"
+	"github.com/zibbp/music-utils/internal/schedule"
+	"github.com/zibbp/music-utils/internal/utils"
+)
+
+// Run statuses
+const (
+	StatusRunning = "running"
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+// Run triggers
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+var (
+	ErrBusy       = errors.New("another job is running")
+	ErrUnknownJob = errors.New("unknown job")
+	ErrNotStarted = errors.New("daemon is not running")
+)
+
+// Job runs its steps in order on a cron schedule. Steps are command names such as "tidal import".
+type Job struct {
+	Name          string   `json:"name"`
+	Schedule      string   `json:"schedule"`
+	Steps         []string `json:"steps"`
+	NotifyWebhook bool     `json:"notify_webhook" mapstructure:"notify_webhook"`
+}
+
+// Run is one run of a job.
+type Run struct {
+	Job      string    `json:"job"`
+	Playlist string    `json:"playlist,omitempty"`
+	Trigger  string    `json:"trigger"`
+	Status   string    `json:"status"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Steps    []Step    `json:"steps"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Step is the result of one step of a run.
+type Step struct {
+	Name     string    `json:"name"`
+	Status   string    `json:"status"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Error    string    `json:"error,omitempty"`
+	// Report is the path of the run report of the step
+	Report string `json:"report,omitempty"`
+}
+
+// JobStatus is the state of a job.
+type JobStatus struct {
+	Job
+	Next    time.Time `json:"next"`
+	Running bool      `json:"running"`
+	LastRun *Run      `json:"last_run,omitempty"`
+}
+
+type scheduledJob struct {
+	Job
+	schedule *schedule.Schedule
+	next     time.Time
+}
+
+// SyncJob is the name of the runs started by TriggerSync
+const SyncJob = "sync"
+
+// Daemon runs jobs on their schedules, one at a time.
+type Daemon struct {
+	// SyncSteps are the steps of a sync started by TriggerSync
+	SyncSteps []string
+
+	jobs        []*scheduledJob
+	historySize int
+
+	// running is held while a job runs, so jobs never overlap
+	running sync.Mutex
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	ctx     context.Context
+	current *Run
+	history []Run
+}
+
+// New checks the jobs and loads the run history. historySize is the number of runs kept.
+func New(jobs []Job, historySize int) (*Daemon, error) {
+	d := &Daemon{historySize: historySize}
+	names := make(map[string]bool)
+	for _, job := range jobs {
+		if job.Name == "" {
+			return nil, errors.New("job without a name")
+		}
+		if names[job.Name] {
+			return nil, fmt.Errorf("duplicate job %s", job.Name)
+		}
+		names[job.Name] = true
+		if len(job.Steps) == 0 {
+			return nil, fmt.Errorf("job %s has no steps", job.Name)
+		}
+		for _, step := range job.Steps {
+			if _, ok := app.Commands[step]; !ok {
+				return nil, fmt.Errorf("job %s has unknown step %q", job.Name, step)
+			}
+		}
+		s, err := schedule.Parse(job.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %w", job.Name, err)
+		}
+		if job.NotifyWebhook {
+			_, err = app.NotificationTargets()
+			if err != nil {
+				return nil, fmt.Errorf("job %s: %w", job.Name, err)
+			}
+		}
+		d.jobs = append(d.jobs, &scheduledJob{Job: job, schedule: s})
+	}
+
+	err := d.loadHistory()
+	if err != nil {
+		log.Error().Err(err).Msg("Error loading run history")
+	}
+	return d, nil
+}
+
+// Run schedules the jobs until ctx is done, then waits for the running job.
+func (d *Daemon) Run(ctx context.Context) error {
+	d.mu.Lock()
+	d.ctx = ctx
+	now := time.Now()
+	if len(d.jobs) == 0 {
+		log.Info().Msg("No jobs scheduled, jobs only run when triggered")
+	}
+	for _, job := range d.jobs {
+		job.next = job.schedule.Next(now)
+		log.Info().Msgf("Job %s scheduled with %s, next run at %s", job.Name, job.schedule, job.next.Format(time.RFC3339))
+	}
+	d.mu.Unlock()
+
+	for {
+		timer := time.NewTimer(time.Until(d.nextRun()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Info().Msg("Stopping, waiting for the running job")
+			d.wg.Wait()
+			return nil
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		var due []*scheduledJob
+		d.mu.Lock()
+		for _, job := range d.jobs {
+			if !job.next.IsZero() && !job.next.After(now) {
+				due = append(due, job)
+				job.next = job.schedule.Next(now)
+			}
+		}
+		d.mu.Unlock()
+		for _, job := range due {
+			err := d.start(job.Job, "", TriggerSchedule)
+			if err != nil {
+				log.Warn().Err(err).Msgf("Skipping scheduled run of job %s", job.Name)
+			}
+		}
+	}
+}
+
+// Trigger starts a job outside of its schedule.
+func (d *Daemon) Trigger(name string) error {
+	for _, job := range d.jobs {
+		if job.Name == name {
+			return d.start(job.Job, "", TriggerManual)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownJob, name)
+}
+
+// TriggerSync runs the sync steps for one playlist, or for all playlists when playlist is empty.
+func (d *Daemon) TriggerSync(playlist string) error {
+	if len(d.SyncSteps) == 0 {
+		return errors.New("no sync steps configured")
+	}
+	for _, step := range d.SyncSteps {
+		if _, ok := app.Commands[step]; !ok {
+			return fmt.Errorf("unknown sync step %q", step)
+		}
+	}
+	return d.start(Job{Name: SyncJob, Steps: d.SyncSteps}, playlist, TriggerManual)
+}
+
+// Jobs returns the state of every job.
+func (d *Daemon) Jobs() []JobStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	statuses := make([]JobStatus, 0, len(d.jobs))
+	for _, job := range d.jobs {
+		status := JobStatus{Job: job.Job, Next: job.next}
+		if d.current != nil && d.current.Job == job.Name {
+			status.Running = true
+		}
+		for i := len(d.history) - 1; i >= 0; i-- {
+			if d.history[i].Job == job.Name && d.history[i].Status != StatusSkipped {
+				run := d.history[i]
+				status.LastRun = &run
+				break
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// History returns the finished runs, oldest first, and the running one.
+func (d *Daemon) History() []Run {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	runs := make([]Run, len(d.history), len(d.history)+1)
+	copy(runs, d.history)
+	if d.current != nil {
+		runs = append(runs, *d.current)
+	}
+	return runs
+}
+
+func (d *Daemon) nextRun() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var next time.Time
+	for _, job := range d.jobs {
+		if !job.next.IsZero() && (next.IsZero() || job.next.Before(next)) {
+			next = job.next
+		}
+	}
+	if next.IsZero() {
+		// No job will run again, check back once a day
+		return time.Now().Add(24 * time.Hour)
+	}
+	return next
+}
+
+// start runs the job in the background unless another job is running.
+// A playlist limits the job to the playlist with that name, if the configured filter allows it.
+func (d *Daemon) start(job Job, playlist string, trigger string) error {
+	d.mu.Lock()
+	ctx := d.ctx
+	d.mu.Unlock()
+	if ctx == nil {
+		return ErrNotStarted
+	}
+
+	if !d.running.TryLock() {
+		now := time.Now()
+		d.record(Run{Job: job.Name, Playlist: playlist, Trigger: trigger, Status: StatusSkipped, Started: now, Finished: now, Error: ErrBusy.Error()})
+		return ErrBusy
+	}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer d.running.Unlock()
+		d.execute(ctx, job, playlist, trigger)
+	}()
+	return nil
+}
+
+// execute runs the steps of a job, a failed step stops the job.
+func (d *Daemon) execute(ctx context.Context, job Job, playlist string, trigger string) {
+	log.Info().Msgf("Starting job %s", job.Name)
+	run := &Run{Job: job.Name, Playlist: playlist, Trigger: trigger, Status: StatusRunning, Started: time.Now()}
+	d.mu.Lock()
+	d.current = run
+	d.mu.Unlock()
+
+	var runErr error
+	var reports []*report.Report
+	opts, err := app.ConfigOptions()
+	if err != nil {
+		runErr = err
+	}
+	// Nobody is there to log in, steps fail when Spotify or Tidal is not authorized
+	opts.Unattended = true
+	if playlist != "" {
+		opts.Filter = opts.Filter.WithInclude(playlist)
+	}
+	for _, name := range job.Steps {
+		if runErr != nil {
+			break
+		}
+		step := Step{Name: name, Started: time.Now()}
+		opts.Report = report.New(name, false)
+		err := runStep(ctx, name, opts)
+		step.Report = app.SaveReport(opts.Report, err)
+		reports = append(reports, opts.Report)
+		step.Finished = time.Now()
+		step.Status = StatusSuccess
+		if err != nil {
+			step.Status, step.Error = StatusFailed, err.Error()
+			runErr = fmt.Errorf("%s: %w", name, err)
+		}
+		d.mu.Lock()
+		run.Steps = append(run.Steps, step)
+		d.mu.Unlock()
+	}
+
+	d.mu.Lock()
+	run.Finished = time.Now()
+	run.Status = StatusSuccess
+	if runErr != nil {
+		run.Status, run.Error = StatusFailed, runErr.Error()
+	}
+	d.current = nil
+	d.mu.Unlock()
+
+	duration := run.Finished.Sub(run.Started).Round(time.Second)
+	if runErr != nil {
+		log.Error().Err(runErr).Msgf("Job %s failed after %s", job.Name, duration)
+	} else {
+		log.Info().Msgf("Finished job %s in %s", job.Name, duration)
+	}
+	if job.NotifyWebhook {
+		summary := fmt.Sprintf("ran %s", utils.JoinWithCommasAnd(job.Steps))
+		err := app.Notify(context.WithoutCancel(ctx), opts, notification.NewEvent(job.Name, summary, run.Started, runErr, reports...))
+		if err != nil {
+			log.Error().Err(err).Msg("Error sending notification")
+		}
+	}
+	d.record(*run)
+
+	if path := viper.GetString("metrics.textfile"); path != "" {
+		err := metrics.WriteTextfile(path)
+		if err != nil {
+			log.Error().Err(err).Msg("Error writing metrics")
+		}
+	}
+}
+
+// runStep runs a command and turns a panic into an error, so a broken job does not stop the daemon.
+func runStep(ctx context.Context, name string, opts app.Options) (err error) {
+	started := time.Now()
+	defer func() {
+		metrics.ObserveRun(name, started, err)
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return app.Commands[name](ctx, opts)
+}
+
+// record adds a run to the history and saves it.
+func (d *Daemon) record(run Run) {
+	d.mu.Lock()
+	d.history = append(d.history, run)
+	if d.historySize > 0 && len(d.history) > d.historySize {
+		d.history = d.history[len(d.history)-d.historySize:]
+	}
+	history := make([]Run, len(d.history))
+	copy(history, d.history)
+	d.mu.Unlock()
+
+	data, err := file.JSONMarshal(history)
+	if err != nil {
+		log.Error().Err(err).Msg("Error marshalling run history")
+		return
+	}
+	err = file.WriteFile(file.RunHistoryPath, data)
+	if err != nil {
+		log.Error().Err(err).Msg("Error writing run history")
+	}
+}
+
+func (d *Daemon) loadHistory() error {
+	data, err := os.ReadFile(file.RunHistoryPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var history []Run
+	err = json.Unmarshal(data, &history)
+	if err != nil {
+		return err
+	}
+	// A run that was interrupted by a restart never finished
+	for i := range history {
+		if history[i].Status == StatusRunning {
+			history[i].Status = StatusFailed
+			history[i].Error = "interrupted"
+		}
+	}
+	d.history = history
+	return nil
+}
@@ -2,14 +2,29 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/rs/zerolog/log"
-	"strings"
+	"github.com/zibbp/music-utils/internal/credits"
+	"github.com/zibbp/music-utils/internal/normalize"
+	"github.com/zibbp/music-utils/internal/version"
 )
 
 type Database struct {
 	DB *sql.DB
+	// DurationTolerance is the maximum length difference in seconds, 0 disables the check
+	DurationTolerance int64
+	// titles indexes all media files by their normalized title, loaded on first use
+	titles map[string][]MediaFile
+}
+
+type MediaFile struct {
+	Path     string
+	Title    string
+	Artist   string
+	Album    string
+	Duration float64
 }
 
 func Setup() (*Database, error) {
@@ -21,33 +36,158 @@ func Setup() (*Database, error) {
 	return &Database{DB: db}, nil
 }
 
-func (d *Database) FindTrack(title, album, artist string) (string, error) {
-	var path string
-	// Attempt to find track by title, album and artist and then mutating the strings
+// FindTrack returns the path of the media file of a track, an empty path if there is none.
+// An error means the track could not be looked up.
+func (d *Database) FindTrack(title, album string, artists []string, duration int64, trackVersion string) (string, error) {
+	v := version.Parse(title, trackVersion)
+	// Compare normalized titles, artists and albums first
+	path, normalizedErr := d.findNormalized(title, album, artists, duration, v)
+	if normalizedErr != nil {
+		log.Error().Err(normalizedErr).Msg("Error loading media files")
+	}
+	if path != "" {
+		return path, nil
+	}
+	var artist string
+	if len(artists) > 0 {
+		artist = artists[0]
+	}
+	// Attempt to find track by title, album and artist
 	// This is not 100% accurate, but it's the best we can do
 	// View missing json tracks to manually import missed ones
-	err := d.DB.QueryRow("SELECT path FROM media_file WHERE title LIKE ? AND artist LIKE ?", "%"+title+"%", "%"+artist+"%").Scan(&path)
+	path, err := d.findPath(duration, v, "title LIKE ? AND artist LIKE ?", "%"+title+"%", "%"+artist+"%")
 	if err != nil {
 		// attempt to find track by album
-		err = d.DB.QueryRow("SELECT path FROM media_file WHERE title LIKE ? AND album LIKE ?", "%"+title+"%", "%"+album+"%").Scan(&path)
+		path, err = d.findPath(duration, v, "title LIKE ? AND album LIKE ?", "%"+title+"%", "%"+album+"%")
+		// Not finding the track is only certain if every lookup worked
+		if errors.Is(err, sql.ErrNoRows) && normalizedErr == nil {
+			return "", nil
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			err = normalizedErr
+		}
 		if err != nil {
-			// Cleanup strings
-			// Get title before first parenthesis
-			title = strings.Split(title, " (")[0]
-			// Replace ’ with '
-			title = strings.ReplaceAll(title, "’", "'")
-			artist = strings.ReplaceAll(artist, "’", "'")
-			err := d.DB.QueryRow("SELECT path FROM media_file WHERE title LIKE ? AND artist LIKE ?", "%"+title+"%", "%"+artist+"%").Scan(&path)
-			if err != nil {
-				// Rplace ' with ’
-				title = strings.ReplaceAll(title, "'", "’")
-				artist = strings.ReplaceAll(artist, "'", "’")
-				err := d.DB.QueryRow("SELECT path FROM media_file WHERE title LIKE ? AND artist LIKE ?", "%"+title+"%", "%"+artist+"%").Scan(&path)
-				if err != nil {
-					return "", fmt.Errorf("error finding track: %w", err)
-				}
-			}
+			return "", fmt.Errorf("error finding track: %w", err)
 		}
 	}
 	return path, nil
 }
+
+// findNormalized looks up media files with the same normalized title and returns the first
+// one of a compatible version sharing an artist, or on the same album, within the duration tolerance.
+func (d *Database) findNormalized(title, album string, artists []string, duration int64, v version.Version) (string, error) {
+	if d.titles == nil {
+		err := d.loadTitles()
+		if err != nil {
+			return "", err
+		}
+	}
+	var albumMatch string
+	trackArtists := make(map[string]bool)
+	for _, artist := range credits.Artists(title, artists) {
+		trackArtists[normalize.Artist(artist)] = true
+	}
+	normalizedAlbum := normalize.Title(album)
+	for _, mediaFile := range d.titles[normalize.Title(title)] {
+		if !d.withinTolerance(mediaFile.Duration, duration) || !version.Compatible(v, version.Parse(mediaFile.Title)) {
+			continue
+		}
+		for _, fileArtist := range credits.Artists(mediaFile.Title, credits.Names(mediaFile.Artist)) {
+			if trackArtists[normalize.Artist(fileArtist)] {
+				return mediaFile.Path, nil
+			}
+		}
+		if albumMatch == "" && normalizedAlbum != "" && normalize.Title(mediaFile.Album) == normalizedAlbum {
+			albumMatch = mediaFile.Path
+		}
+	}
+	return albumMatch, nil
+}
+
+func (d *Database) loadTitles() error {
+	titles := make(map[string][]MediaFile)
+	rows, err := d.DB.Query("SELECT path, title, artist, album, duration FROM media_file")
+	if err != nil {
+		return fmt.Errorf("error querying media files: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var mediaFile MediaFile
+		err := rows.Scan(&mediaFile.Path, &mediaFile.Title, &mediaFile.Artist, &mediaFile.Album, &mediaFile.Duration)
+		if err != nil {
+			return fmt.Errorf("error scanning media file: %w", err)
+		}
+		key := normalize.Title(mediaFile.Title)
+		titles[key] = append(titles[key], mediaFile)
+	}
+	err = rows.Err()
+	if err != nil {
+		return fmt.Errorf("error reading media files: %w", err)
+	}
+	// Only a complete index is kept, a failed load is retried on the next lookup
+	d.titles = titles
+	log.Debug().Msgf("Indexed %d normalized Navidrome titles", len(d.titles))
+	return nil
+}
+
+func (d *Database) withinTolerance(fileDuration float64, duration int64) bool {
+	if d.DurationTolerance == 0 || duration == 0 || fileDuration == 0 {
+		return true
+	}
+	delta := int64(fileDuration) - duration
+	if delta < 0 {
+		delta = -delta
+	}
+	return delta <= d.DurationTolerance
+}
+
+// findPath selects the path of the first media file of a compatible version matching the condition.
+// If a duration is provided, files outside the duration tolerance are ignored.
+func (d *Database) findPath(duration int64, v version.Version, condition string, args ...interface{}) (string, error) {
+	query := "SELECT path, title FROM media_file WHERE " + condition
+	if d.DurationTolerance > 0 && duration > 0 {
+		query += " AND ABS(duration - ?) <= ?"
+		args = append(args, duration, d.DurationTolerance)
+	}
+	rows, err := d.DB.Query(query, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path, title string
+		err := rows.Scan(&path, &title)
+		if err != nil {
+			return "", err
+		}
+		if version.Compatible(v, version.Parse(title)) {
+			return path, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return "", sql.ErrNoRows
+}
+
+// SearchTracks returns media files whose title contains the given title, files by the artist first.
+func (d *Database) SearchTracks(title, artist string, limit int) ([]MediaFile, error) {
+	rows, err := d.DB.Query("SELECT path, title, artist, album, duration FROM media_file WHERE title LIKE ? ORDER BY artist LIKE ? DESC LIMIT ?", "%"+title+"%", "%"+artist+"%", limit)
+	if err != nil {
+		return nil, fmt.Errorf("error searching tracks: %w", err)
+	}
+	defer rows.Close()
+
+	var mediaFiles []MediaFile
+	for rows.Next() {
+		var mediaFile MediaFile
+		err := rows.Scan(&mediaFile.Path, &mediaFile.Title, &mediaFile.Artist, &mediaFile.Album, &mediaFile.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning track: %w", err)
+		}
+		mediaFiles = append(mediaFiles, mediaFile)
+	}
+	return mediaFiles, rows.Err()
+}
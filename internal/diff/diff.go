@@ -0,0 +1,40 @@
+package diff
+
+// Diff is the difference between a source playlist and its target.
+type Diff[K comparable] struct {
+	// Added are the source items missing from the target, in source order
+	Added []K
+	// Removed are the target indexes of items no longer in the source, in ascending order
+	Removed []int
+	// Unchanged are the items in both
+	Unchanged []K
+}
+
+// Compute compares the source items with the target items.
+// Duplicates are matched one to one, so an item that is twice in the target but once in the source is removed once.
+func Compute[K comparable](source []K, target []K) Diff[K] {
+	wanted := make(map[K]int, len(source))
+	for _, item := range source {
+		wanted[item]++
+	}
+
+	var d Diff[K]
+	present := make(map[K]int, len(target))
+	for i, item := range target {
+		if wanted[item] > 0 {
+			wanted[item]--
+			present[item]++
+			d.Unchanged = append(d.Unchanged, item)
+			continue
+		}
+		d.Removed = append(d.Removed, i)
+	}
+	for _, item := range source {
+		if present[item] > 0 {
+			present[item]--
+			continue
+		}
+		d.Added = append(d.Added, item)
+	}
+	return d
+}
@@ -0,0 +1,71 @@
+package explain
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/zibbp/music-utils/internal/matcher"
+)
+
+// Decisions recorded for a track.
+const (
+	DecisionOverride      = "override"
+	DecisionInPlaylist    = "in_playlist"
+	DecisionCached        = "cached"
+	DecisionCachedMissing = "cached_missing"
+	DecisionMatched       = "matched"
+	DecisionMissing       = "missing"
+	DecisionError         = "error"
+)
+
+// Trace describes how a single source track was resolved.
+type Trace struct {
+	Time       time.Time        `json:"time"`
+	Playlist   string           `json:"playlist"`
+	Source     matcher.Track    `json:"source"`
+	Query      string           `json:"query,omitempty"`
+	Decision   string           `json:"decision"`
+	Candidates []matcher.Result `json:"candidates,omitempty"`
+	Error      string           `json:"error,omitempty"`
+}
+
+// Writer appends traces to a JSONL file.
+// A nil *Writer is valid and discards every trace.
+type Writer struct {
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// Create truncates or creates the trace file at path.
+func Create(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating explain file: %w", err)
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetEscapeHTML(false)
+	return &Writer{file: f, encoder: encoder}, nil
+}
+
+func (w *Writer) Write(trace Trace) error {
+	if w == nil {
+		return nil
+	}
+	if trace.Time.IsZero() {
+		trace.Time = time.Now()
+	}
+	err := w.encoder.Encode(trace)
+	if err != nil {
+		return fmt.Errorf("error writing explain trace: %w", err)
+	}
+	return nil
+}
+
+func (w *Writer) Close() error {
+	if w == nil {
+		return nil
+	}
+	return w.file.Close()
+}
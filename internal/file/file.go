@@ -6,11 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/kennygrant/sanitize"
 	"github.com/rs/zerolog/log"
 	"github.com/zibbp/music-utils/internal/lidarr"
+	"github.com/zibbp/music-utils/internal/plan"
 	"github.com/zibbp/music-utils/internal/tidal"
 	"github.com/zmb3/spotify/v2"
 	spotifyPkg "github.com/zmb3/spotify/v2"
@@ -23,11 +25,58 @@ type MissingTrack struct {
 }
 
 type MissingTrackNavidrome struct {
-	Name    string         `json:"name"`
-	Album   string         `json:"album"`
-	Artists []tidal.Artist `json:"artists"`
+	ID       int64          `json:"id"`
+	Name     string         `json:"name"`
+	Album    string         `json:"album"`
+	Artists  []tidal.Artist `json:"artists"`
+	Duration int64          `json:"duration"`
 }
 
+// MissingTrackSpotify is a Tidal track that was not found on Spotify
+type MissingTrackSpotify struct {
+	ID       int64          `json:"id"`
+	ISRC     string         `json:"isrc"`
+	Name     string         `json:"name"`
+	Album    string         `json:"album"`
+	Artists  []tidal.Artist `json:"artists"`
+	Duration int64          `json:"duration"`
+}
+
+const (
+	MatchStatusAdded      = "added"
+	MatchStatusInPlaylist = "in_playlist"
+	MatchStatusMissing    = "missing"
+	MatchStatusFailed     = "failed"
+	// MatchStatusError is a track whose lookup failed, it keeps the Tidal track of its previous match
+	MatchStatusError = "error"
+)
+
+type MatchedTrack struct {
+	SpotifyID    string   `json:"spotify_id"`
+	ISRC         string   `json:"isrc"`
+	Name         string   `json:"name"`
+	Artists      []string `json:"artists"`
+	Album        string   `json:"album"`
+	Duration     int64    `json:"duration"`
+	TidalID      string   `json:"tidal_id,omitempty"`
+	TidalTitle   string   `json:"tidal_title,omitempty"`
+	TidalArtists []string `json:"tidal_artists,omitempty"`
+	Confidence   float64  `json:"confidence"`
+	Status       string   `json:"status"`
+}
+
+// NavidromeMatch is the m3u8 line a Tidal track was exported as
+type NavidromeMatch struct {
+	TidalID int64  `json:"tidal_id"`
+	Path    string `json:"path"`
+}
+
+// RunHistoryPath is the file the run history of the daemon is kept in
+const RunHistoryPath = "/data/runs/history.json"
+
+// dryRun records writes instead of making them when set
+var dryRun *plan.Plan
+
 type MissingLidarrAlbum struct {
 	Name   string `json:"name"`
 	Artist string `json:"artist"`
@@ -43,6 +92,10 @@ func Initialize() error {
 	if err != nil {
 		return err
 	}
+	err = createFolderIfNotExists("./data/matches")
+	if err != nil {
+		return err
+	}
 	err = createFolderIfNotExists("./data/tidal")
 	if err != nil {
 		return err
@@ -51,10 +104,26 @@ func Initialize() error {
 	if err != nil {
 		return err
 	}
+	err = createFolderIfNotExists("./data/navidrome-matches")
+	if err != nil {
+		return err
+	}
 	err = createFolderIfNotExists("./data/wanted")
 	if err != nil {
 		return err
 	}
+	err = createFolderIfNotExists("./data/runs")
+	if err != nil {
+		return err
+	}
+	err = createFolderIfNotExists("./data/spotify-missing")
+	if err != nil {
+		return err
+	}
+	err = createFolderIfNotExists("./data/reports")
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -69,7 +138,16 @@ func createFolderIfNotExists(path string) error {
 	return nil
 }
 
+// SetPlan makes every write record an action in p instead of changing files. A nil p writes again.
+func SetPlan(p *plan.Plan) {
+	dryRun = p
+}
+
 func WriteFile(path string, data []byte) error {
+	if dryRun != nil {
+		dryRun.Record(plan.ActionWriteFile, path)
+		return nil
+	}
 	err := os.WriteFile(path, []byte(data), 0644)
 	if err != nil {
 		return err
@@ -148,6 +226,84 @@ func WriteMissingTracks(tracks []MissingTrack, name string) error {
 	return nil
 }
 
+func WriteMatchedTracks(tracks []MatchedTrack, name string) error {
+	data, err := JSONMarshal(tracks)
+	if err != nil {
+		return fmt.Errorf("error marshalling matched tracks: %w", err)
+	}
+
+	// Sanitize playlist name
+	playlistName := sanitize.BaseName(name)
+
+	err = WriteFile(fmt.Sprintf("/data/matches/%s.json", playlistName), data)
+	if err != nil {
+		return fmt.Errorf("error writing matched tracks file: %w", err)
+	}
+
+	return nil
+}
+
+// ReadMatchedTracks reads the matched tracks of every playlist
+func ReadMatchedTracks() ([]MatchedTrack, error) {
+	tracks, err := readAllPlaylistFiles[MatchedTrack]("/data/matches")
+	if err != nil {
+		return nil, fmt.Errorf("error reading matched tracks files: %w", err)
+	}
+	return tracks, nil
+}
+
+// WriteNavidromeMatches writes the m3u8 lines the tracks of a playlist were exported as
+func WriteNavidromeMatches(matches []NavidromeMatch, name string) error {
+	data, err := JSONMarshal(matches)
+	if err != nil {
+		return fmt.Errorf("error marshalling navidrome matches: %w", err)
+	}
+	err = WriteFile(fmt.Sprintf("/data/navidrome-matches/%s.json", sanitize.BaseName(name)), data)
+	if err != nil {
+		return fmt.Errorf("error writing navidrome matches file: %w", err)
+	}
+	return nil
+}
+
+// ReadNavidromeMatches reads the matches of the previous export of a playlist, nil if it was never exported
+func ReadNavidromeMatches(name string) ([]NavidromeMatch, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/data/navidrome-matches/%s.json", sanitize.BaseName(name)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading navidrome matches file: %w", err)
+	}
+	var matches []NavidromeMatch
+	err = json.Unmarshal(data, &matches)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling navidrome matches file: %w", err)
+	}
+	return matches, nil
+}
+
+// ReadPlaylistMatchedTracks reads the matched tracks of the previous import of a playlist
+func ReadPlaylistMatchedTracks(name string) ([]MatchedTrack, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/data/matches/%s.json", sanitize.BaseName(name)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading matched tracks file: %w", err)
+	}
+	var tracks []MatchedTrack
+	err = json.Unmarshal(data, &tracks)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling matched tracks file: %w", err)
+	}
+	return tracks, nil
+}
+
+// ExplainPath returns the path of the explain trace file for a playlist, next to its missing tracks file
+func ExplainPath(name string) string {
+	return fmt.Sprintf("/data/missing/%s.explain.jsonl", sanitize.BaseName(name))
+}
+
 func ReadUsersPlaylists() ([]spotify.FullPlaylist, error) {
 	// Read all playlist files
 	files, err := os.ReadDir("/data/spotify")
@@ -231,6 +387,10 @@ func CreateM3U8PlaylistFile(name string) error {
 	playlistName := sanitize.BaseName(name)
 	filePath := fmt.Sprintf("/playlists/%s.m3u8", playlistName)
 	if _, err := os.Stat(filePath); errors.Is(err, os.ErrNotExist) {
+		if dryRun != nil {
+			dryRun.Record(plan.ActionWriteFile, filePath)
+			return nil
+		}
 		file, err := os.Create(filePath)
 		if err != nil {
 			return fmt.Errorf("error creating playlist file: %w", err)
@@ -247,6 +407,16 @@ func AddTrackToM3U8PlaylistFile(name string, trackPath string) error {
 	// Append track to playlist file is not already in it
 	playlistName := sanitize.BaseName(name)
 	filePath := fmt.Sprintf("/playlists/%s.m3u8", playlistName)
+	if dryRun != nil {
+		data, err := os.ReadFile(filePath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error reading playlist file: %w", err)
+		}
+		if !strings.Contains(string(data), trackPath) {
+			dryRun.Record(plan.ActionWriteM3U8, filePath, trackPath)
+		}
+		return nil
+	}
 	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0600)
 	if err != nil {
 		return fmt.Errorf("error opening playlist file: %w", err)
@@ -270,6 +440,47 @@ func AddTrackToM3U8PlaylistFile(name string, trackPath string) error {
 	return nil
 }
 
+// ReadM3U8PlaylistFile returns the track paths of a playlist file
+func ReadM3U8PlaylistFile(name string) ([]string, error) {
+	playlistName := sanitize.BaseName(name)
+	data, err := os.ReadFile(fmt.Sprintf("/playlists/%s.m3u8", playlistName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading playlist file: %w", err)
+	}
+	var trackPaths []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		trackPaths = append(trackPaths, line)
+	}
+	return trackPaths, nil
+}
+
+// WriteM3U8PlaylistFile replaces the tracks of a playlist file
+func WriteM3U8PlaylistFile(name string, trackPaths []string) error {
+	playlistName := sanitize.BaseName(name)
+	if dryRun != nil {
+		dryRun.Record(plan.ActionWriteM3U8, fmt.Sprintf("/playlists/%s.m3u8", playlistName), trackPaths...)
+		return nil
+	}
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	for _, trackPath := range trackPaths {
+		sb.WriteString(trackPath)
+		sb.WriteString("\n")
+	}
+	err := WriteFile(fmt.Sprintf("/playlists/%s.m3u8", playlistName), []byte(sb.String()))
+	if err != nil {
+		return fmt.Errorf("error writing playlist file: %w", err)
+	}
+	return nil
+}
+
 func ReadTidalPlaylistsToSave() ([]string, error) {
 	// Read playlists.txt
 	data, err := os.ReadFile("/data/tidal/playlists.txt")
@@ -290,8 +501,10 @@ func ProcessMissingNavidromeTracks(missingTracks []tidal.Track, playlistName str
 	var tracks []MissingTrackNavidrome
 	for _, track := range missingTracks {
 		newTrack := MissingTrackNavidrome{
-			Name:  track.Title,
-			Album: track.Album.Title,
+			ID:       track.ID,
+			Name:     track.Title,
+			Album:    track.Album.Title,
+			Duration: track.Duration,
 		}
 		for _, artist := range track.Artists {
 			newTrack.Artists = append(newTrack.Artists, artist)
@@ -322,6 +535,94 @@ func WriteMissingNavidromeTracks(tracks []MissingTrackNavidrome, name string) er
 	return nil
 }
 
+// ProcessMissingSpotifyTracks writes the Tidal tracks of a playlist that were not found on Spotify
+func ProcessMissingSpotifyTracks(missingTracks []tidal.Track, playlistName string) error {
+	var tracks []MissingTrackSpotify
+	for _, track := range missingTracks {
+		tracks = append(tracks, MissingTrackSpotify{
+			ID:       track.ID,
+			ISRC:     track.Isrc,
+			Name:     track.Title,
+			Album:    track.Album.Title,
+			Artists:  track.Artists,
+			Duration: track.Duration,
+		})
+	}
+	data, err := JSONMarshal(tracks)
+	if err != nil {
+		return fmt.Errorf("error marshalling missing tracks: %w", err)
+	}
+	err = WriteFile(fmt.Sprintf("/data/spotify-missing/%s.json", sanitize.BaseName(playlistName)), data)
+	if err != nil {
+		return fmt.Errorf("error writing missing tracks file: %w", err)
+	}
+	return nil
+}
+
+// ReadMissingNavidromeTracks reads the missing Navidrome tracks of every playlist
+func ReadMissingNavidromeTracks() ([]MissingTrackNavidrome, error) {
+	tracks, err := readAllPlaylistFiles[MissingTrackNavidrome]("/data/navidrome-missing")
+	if err != nil {
+		return nil, fmt.Errorf("error reading missing tracks files: %w", err)
+	}
+	return tracks, nil
+}
+
+// ReadMissingTracksByPlaylist reads the tracks missing on Tidal, by playlist file name
+func ReadMissingTracksByPlaylist() (map[string][]MissingTrack, error) {
+	return readPlaylistFiles[MissingTrack]("/data/missing")
+}
+
+// ReadMissingNavidromeTracksByPlaylist reads the tracks missing in Navidrome, by playlist file name
+func ReadMissingNavidromeTracksByPlaylist() (map[string][]MissingTrackNavidrome, error) {
+	return readPlaylistFiles[MissingTrackNavidrome]("/data/navidrome-missing")
+}
+
+// readPlaylistFiles reads every JSON file in dir, keyed by the file name without extension
+func readPlaylistFiles[T any](dir string) (map[string][]T, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", dir, err)
+	}
+
+	playlists := make(map[string][]T)
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(fmt.Sprintf("%s/%s", dir, file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", file.Name(), err)
+		}
+		var items []T
+		err = json.Unmarshal(data, &items)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling %s: %w", file.Name(), err)
+		}
+		playlists[strings.TrimSuffix(file.Name(), ".json")] = items
+	}
+	return playlists, nil
+}
+
+// readAllPlaylistFiles reads every JSON file in dir into one slice, in file name order
+func readAllPlaylistFiles[T any](dir string) ([]T, error) {
+	playlists, err := readPlaylistFiles[T](dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(playlists))
+	for name := range playlists {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var items []T
+	for _, name := range names {
+		items = append(items, playlists[name]...)
+	}
+	return items, nil
+}
+
 func WriteWantedLinks(links []string) error {
 	// Write array of strings to text file
 	data := strings.Join(links, "\n")
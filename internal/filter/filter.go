@@ -0,0 +1,142 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	globPrefix  = "glob:"
+	regexPrefix = "regex:"
+)
+
+// Filter decides which playlists are handled. A nil Filter allows every playlist.
+type Filter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	// only are playlist names added by WithInclude, a playlist must have all of them
+	only []string
+	// SpotifyOwners limits Spotify playlists to these owner IDs
+	SpotifyOwners []string
+	// TidalCreators limits Tidal playlists to these creator IDs
+	TidalCreators []string
+	// OwnedOnly limits playlists to the ones owned by the user
+	OwnedOnly bool
+}
+
+// New compiles the include and exclude patterns. A pattern is an exact playlist name,
+// "glob:" followed by a glob with * and ? or "regex:" followed by a regular expression.
+func New(include, exclude, spotifyOwners, tidalCreators []string, ownedOnly bool) (*Filter, error) {
+	f := &Filter{SpotifyOwners: spotifyOwners, TidalCreators: tidalCreators, OwnedOnly: ownedOnly}
+	var err error
+	f.include, err = compile(include)
+	if err != nil {
+		return nil, err
+	}
+	f.exclude, err = compile(exclude)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// WithInclude returns a copy of the filter that only allows the playlist named name,
+// if the other rules of the filter allow it as well.
+func (f *Filter) WithInclude(name string) *Filter {
+	narrowed := &Filter{}
+	if f != nil {
+		*narrowed = *f
+	}
+	narrowed.only = append(slices.Clone(narrowed.only), name)
+	return narrowed
+}
+
+// Spotify reports whether a Spotify playlist is handled. userID is the ID of the current user.
+func (f *Filter) Spotify(name, ownerID, userID string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.SpotifyOwners) > 0 && !slices.Contains(f.SpotifyOwners, ownerID) {
+		return false
+	}
+	if f.OwnedOnly && ownerID != userID {
+		return false
+	}
+	return f.name(name)
+}
+
+// Tidal reports whether a Tidal playlist is handled. userID is the ID of the current user.
+func (f *Filter) Tidal(name, creatorID, userID string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.TidalCreators) > 0 && !slices.Contains(f.TidalCreators, creatorID) {
+		return false
+	}
+	if f.OwnedOnly && creatorID != userID {
+		return false
+	}
+	return f.name(name)
+}
+
+// name checks the include and exclude patterns, excludes win.
+func (f *Filter) name(name string) bool {
+	for _, only := range f.only {
+		if name != only {
+			return false
+		}
+	}
+	for _, re := range f.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func compile(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		var expr string
+		switch {
+		case strings.HasPrefix(pattern, regexPrefix):
+			expr = strings.TrimPrefix(pattern, regexPrefix)
+		case strings.HasPrefix(pattern, globPrefix):
+			expr = "^" + globToRegex(strings.TrimPrefix(pattern, globPrefix)) + "$"
+		default:
+			expr = "^" + regexp.QuoteMeta(pattern) + "$"
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid playlist pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// globToRegex converts * and ? to their regular expression, everything else is literal.
+func globToRegex(glob string) string {
+	var sb strings.Builder
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return sb.String()
+}
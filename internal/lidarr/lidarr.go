@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
+	"github.com/zibbp/music-utils/internal/metrics"
 	"io"
 	"net/http"
 )
 
+var client = &http.Client{Transport: &metrics.Transport{Provider: "lidarr"}}
+
 func UnmarshalWanted(data []byte) (Wanted, error) {
 	var r Wanted
 	err := json.Unmarshal(data, &r)
@@ -179,7 +182,7 @@ func (s *Service) GetWanted() ([]Record, error) {
 	}
 	req.Header.Set("X-Api-Key", s.ApiKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		log.Error().Err(err).Msg("Error getting wanted albums")
 		return nil, err
@@ -0,0 +1,334 @@
+package matcher
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zibbp/music-utils/internal/credits"
+	"github.com/zibbp/music-utils/internal/database"
+	"github.com/zibbp/music-utils/internal/normalize"
+	"github.com/zibbp/music-utils/internal/tidal"
+	"github.com/zibbp/music-utils/internal/version"
+	spotifyPkg "github.com/zmb3/spotify/v2"
+)
+
+// Component weights used when scoring a candidate. ISRC is only counted
+// when both tracks carry the same code, a differing ISRC is common for
+// re-releases of the same recording and is not treated as a mismatch.
+const (
+	weightISRC     = 3.0
+	weightTitle    = 3.0
+	weightArtist   = 2.5
+	weightAlbum    = 1.0
+	weightDuration = 1.5
+	weightExplicit = 0.5
+	weightVersion  = 1.0
+)
+
+// passScore is the score a similarity component needs to be reported as passed.
+const passScore = 0.8
+
+// DefaultThreshold is the minimum confidence a candidate needs to be accepted.
+const DefaultThreshold = 0.75
+
+// DefaultDurationTolerance is the maximum length difference in seconds between two tracks.
+const DefaultDurationTolerance = 3
+
+// revision changes whenever the scoring changes, so matches cached by an older matcher are not reused.
+const revision = 4
+
+// Track is the provider independent representation of a track used for matching.
+type Track struct {
+	ID       string   `json:"id"`
+	Title    string   `json:"title"`
+	Artists  []string `json:"artists"`
+	Album    string   `json:"album"`
+	ISRC     string   `json:"isrc"`
+	Duration int64    `json:"duration"` // seconds
+	Explicit bool     `json:"explicit"`
+	Version  string   `json:"version,omitempty"` // version the provider reports separately from the title
+}
+
+// Component is the score of a single matching rule.
+type Component struct {
+	Name   string  `json:"name"`
+	Score  float64 `json:"score"`
+	Weight float64 `json:"weight"`
+	Passed bool    `json:"passed"`
+	Detail string  `json:"detail,omitempty"`
+}
+
+// Result is a scored candidate.
+type Result struct {
+	Index         int         `json:"-"` // position of the candidate in the slice passed to Rank
+	Candidate     Track       `json:"candidate"`
+	Confidence    float64     `json:"confidence"`
+	DurationDelta int64       `json:"duration_delta"`
+	Compatible    bool        `json:"compatible"` // whether both tracks are the same version of the recording
+	Components    []Component `json:"components"`
+	Accepted      bool        `json:"accepted"`
+	Reason        string      `json:"reason,omitempty"`
+}
+
+// Explicit preferences, which edition to pick if both an explicit and a clean one match.
+const (
+	ExplicitSource = "source"
+	ExplicitAlways = "explicit"
+	ExplicitClean  = "clean"
+)
+
+type Matcher struct {
+	Threshold float64
+	// DurationTolerance is the maximum length difference in seconds, 0 disables the check.
+	DurationTolerance int64
+	// ExplicitPreference is one of ExplicitSource, ExplicitAlways or ExplicitClean.
+	ExplicitPreference string
+}
+
+func New(threshold float64, durationTolerance int64, explicitPreference string) *Matcher {
+	if threshold <= 0 {
+		threshold = DefaultThreshold
+	}
+	if durationTolerance < 0 {
+		durationTolerance = 0
+	}
+	switch explicitPreference {
+	case ExplicitAlways, ExplicitClean:
+	default:
+		explicitPreference = ExplicitSource
+	}
+	return &Matcher{Threshold: threshold, DurationTolerance: durationTolerance, ExplicitPreference: explicitPreference}
+}
+
+// Fingerprint identifies the scoring and configuration of the matcher. Cached matches are only
+// reused by a matcher with the same fingerprint.
+func (m *Matcher) Fingerprint() string {
+	return fmt.Sprintf("%d:%s:%d:%s", revision, strconv.FormatFloat(m.Threshold, 'f', -1, 64), m.DurationTolerance, m.ExplicitPreference)
+}
+
+// wantExplicit returns whether the explicit edition of the source track is preferred.
+func (m *Matcher) wantExplicit(source Track) bool {
+	switch m.ExplicitPreference {
+	case ExplicitAlways:
+		return true
+	case ExplicitClean:
+		return false
+	}
+	return source.Explicit
+}
+
+// Score compares a candidate against the source track and returns a confidence between 0 and 1.
+func (m *Matcher) Score(source, candidate Track) Result {
+	var components []Component
+
+	isrc := Component{Name: "isrc", Detail: fmt.Sprintf("%q vs %q", source.ISRC, candidate.ISRC)}
+	if source.ISRC != "" && strings.EqualFold(source.ISRC, candidate.ISRC) {
+		isrc.Score, isrc.Weight, isrc.Passed = 1, weightISRC, true
+	}
+	components = append(components, isrc)
+
+	sourceTitle, candidateTitle := normalize.Title(source.Title), normalize.Title(candidate.Title)
+	title := similarity(sourceTitle, candidateTitle)
+	components = append(components, Component{Name: "title", Score: title, Weight: weightTitle, Passed: title >= passScore, Detail: fmt.Sprintf("%q vs %q", sourceTitle, candidateTitle)})
+
+	sourceArtists, candidateArtists := credits.Artists(source.Title, source.Artists), credits.Artists(candidate.Title, candidate.Artists)
+	artist := artistOverlap(sourceArtists, candidateArtists)
+	components = append(components, Component{Name: "artist", Score: artist, Weight: weightArtist, Passed: artist >= passScore, Detail: fmt.Sprintf("%q vs %q", sourceArtists, candidateArtists)})
+
+	if source.Album != "" && candidate.Album != "" {
+		sourceAlbum, candidateAlbum := normalize.Title(source.Album), normalize.Title(candidate.Album)
+		album := similarity(sourceAlbum, candidateAlbum)
+		components = append(components, Component{Name: "album", Score: album, Weight: weightAlbum, Passed: album >= passScore, Detail: fmt.Sprintf("%q vs %q", sourceAlbum, candidateAlbum)})
+	}
+	var delta int64
+	if source.Duration > 0 && candidate.Duration > 0 {
+		delta = durationDelta(source.Duration, candidate.Duration)
+		components = append(components, Component{Name: "duration", Score: m.durationScore(delta), Weight: weightDuration, Passed: delta <= m.DurationTolerance, Detail: fmt.Sprintf("%ds vs %ds, delta %ds", source.Duration, candidate.Duration, delta)})
+	}
+	sourceVersion, candidateVersion := version.Parse(source.Title, source.Version), version.Parse(candidate.Title, candidate.Version)
+	compatible := version.Compatible(sourceVersion, candidateVersion)
+	versionComponent := Component{Name: "version", Weight: weightVersion, Passed: compatible, Detail: fmt.Sprintf("%s vs %s", sourceVersion, candidateVersion)}
+	if compatible {
+		versionComponent.Score = 1
+	}
+	components = append(components, versionComponent)
+
+	wantExplicit := m.wantExplicit(source)
+	explicit := Component{Name: "explicit", Weight: weightExplicit, Detail: fmt.Sprintf("%t vs %t, preference %s", source.Explicit, candidate.Explicit, m.ExplicitPreference)}
+	if wantExplicit == candidate.Explicit {
+		explicit.Score, explicit.Passed = 1, true
+	}
+	components = append(components, explicit)
+
+	var total, weights float64
+	for _, c := range components {
+		total += c.Score * c.Weight
+		weights += c.Weight
+	}
+
+	return Result{
+		Candidate:     candidate,
+		Confidence:    total / weights,
+		DurationDelta: delta,
+		Compatible:    compatible,
+		Components:    components,
+	}
+}
+
+// Rank scores every candidate and returns them ordered by confidence, best first.
+func (m *Matcher) Rank(source Track, candidates []Track) []Result {
+	results := make([]Result, 0, len(candidates))
+	for i, candidate := range candidates {
+		result := m.Score(source, candidate)
+		result.Index = i
+		results = append(results, result)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Confidence > results[j].Confidence
+	})
+	return results
+}
+
+// Evaluate ranks the candidates and marks the accepted one. Every other candidate gets the reason it was rejected.
+// Among the candidates that pass every check the best one is accepted, unless an edition of the same recording
+// matches the explicit preference.
+func (m *Matcher) Evaluate(source Track, candidates []Track) []Result {
+	results := m.Rank(source, candidates)
+	var eligible []int
+	for i := range results {
+		result := &results[i]
+		switch {
+		case !result.Compatible:
+			result.Reason = "the candidate is a different version of the track"
+		case !m.WithinTolerance(*result):
+			result.Reason = fmt.Sprintf("duration differs by %ds, tolerance is %ds", result.DurationDelta, m.DurationTolerance)
+		case result.Confidence < m.Threshold:
+			result.Reason = fmt.Sprintf("confidence %.2f is below the threshold of %.2f", result.Confidence, m.Threshold)
+		default:
+			eligible = append(eligible, i)
+		}
+	}
+	if len(eligible) == 0 {
+		return results
+	}
+
+	accepted := eligible[0]
+	wantExplicit := m.wantExplicit(source)
+	for _, i := range eligible {
+		if results[i].Candidate.Explicit == wantExplicit && sameRecording(results[i].Candidate, results[eligible[0]].Candidate) {
+			accepted = i
+			break
+		}
+	}
+	for _, i := range eligible {
+		switch {
+		case i == accepted:
+			results[i].Accepted = true
+		case i < accepted:
+			results[i].Reason = "a candidate matching the explicit preference was accepted"
+		default:
+			results[i].Reason = "a candidate with a higher confidence was accepted"
+		}
+	}
+	return results
+}
+
+// sameRecording reports whether two tracks are editions of one recording: the same base title,
+// version and primary artist.
+func sameRecording(a, b Track) bool {
+	if len(a.Artists) == 0 || len(b.Artists) == 0 || normalize.Artist(a.Artists[0]) != normalize.Artist(b.Artists[0]) {
+		return false
+	}
+	return normalize.Title(a.Title) == normalize.Title(b.Title) &&
+		version.Parse(a.Title, a.Version).String() == version.Parse(b.Title, b.Version).String()
+}
+
+// Best returns the highest scoring candidate of a compatible version within the duration tolerance.
+// The bool is false if no such candidate reaches the threshold.
+func (m *Matcher) Best(source Track, candidates []Track) (Result, bool) {
+	return m.Pick(m.Evaluate(source, candidates))
+}
+
+// Pick returns the accepted result of Evaluate. If none was accepted the best
+// compatible result within the duration tolerance is returned for reporting.
+func (m *Matcher) Pick(results []Result) (Result, bool) {
+	if len(results) == 0 {
+		return Result{}, false
+	}
+	for _, result := range results {
+		if result.Accepted {
+			return result, true
+		}
+	}
+	for _, result := range results {
+		if result.Compatible && m.WithinTolerance(result) {
+			return result, false
+		}
+	}
+	// Nothing compatible within tolerance, report the best candidate
+	return results[0], false
+}
+
+// WithinTolerance reports whether the length difference of a scored candidate is acceptable.
+func (m *Matcher) WithinTolerance(result Result) bool {
+	return m.DurationTolerance == 0 || result.DurationDelta <= m.DurationTolerance
+}
+
+// durationScore is 1 within the tolerance and decays linearly to 0 at a 30 second difference.
+func (m *Matcher) durationScore(delta int64) float64 {
+	if delta <= m.DurationTolerance {
+		return 1
+	}
+	if delta >= 30 {
+		return 0
+	}
+	return 1 - float64(delta-m.DurationTolerance)/float64(30-m.DurationTolerance)
+}
+
+func FromSpotifyTrack(track spotifyPkg.FullTrack) Track {
+	t := Track{
+		ID:       track.ID.String(),
+		Title:    track.Name,
+		Album:    track.Album.Name,
+		ISRC:     track.ExternalIDs["isrc"],
+		Duration: int64(track.Duration / 1000),
+		Explicit: track.Explicit,
+	}
+	for _, artist := range track.Artists {
+		t.Artists = append(t.Artists, artist.Name)
+	}
+	return t
+}
+
+func FromTidalTrack(track tidal.Track) Track {
+	t := Track{
+		ID:       strconv.FormatInt(track.ID, 10),
+		Title:    track.Title,
+		Album:    track.Album.Title,
+		ISRC:     track.Isrc,
+		Duration: track.Duration,
+		Explicit: track.Explicit,
+	}
+	for _, artist := range track.Artists {
+		t.Artists = append(t.Artists, artist.Name)
+	}
+	if track.Version != nil {
+		t.Version = *track.Version
+	}
+	if len(t.Artists) == 0 && track.Artist.Name != "" {
+		t.Artists = append(t.Artists, track.Artist.Name)
+	}
+	return t
+}
+
+func FromMediaFile(mediaFile database.MediaFile) Track {
+	return Track{
+		ID:       mediaFile.Path,
+		Title:    mediaFile.Title,
+		Artists:  credits.Names(mediaFile.Artist),
+		Album:    mediaFile.Album,
+		Duration: int64(mediaFile.Duration),
+	}
+}
@@ -0,0 +1,57 @@
+package matcher
+
+import "github.com/zibbp/music-utils/internal/normalize"
+
+// similarity returns the Levenshtein ratio of two strings, 1 being identical.
+func similarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return 1 - float64(prev[len(rb)])/float64(max(len(ra), len(rb)))
+}
+
+// artistOverlap returns the share of the smaller artist set that is present in the other one.
+func artistOverlap(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	set := make(map[string]bool, len(b))
+	for _, artist := range b {
+		set[normalize.Artist(artist)] = true
+	}
+	var shared int
+	for _, artist := range a {
+		if set[normalize.Artist(artist)] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(min(len(a), len(b)))
+}
+
+// durationDelta returns the absolute difference between two lengths in seconds.
+func durationDelta(a, b int64) int64 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
@@ -0,0 +1,212 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metric names
+const (
+	PlaylistsProcessed = "music_utils_playlists_processed_total"
+	TracksMatched      = "music_utils_tracks_matched_total"
+	TracksMissing      = "music_utils_tracks_missing_total"
+	LidarrAlbums       = "music_utils_lidarr_albums_total"
+	APIRequests        = "music_utils_api_requests_total"
+	APIErrors          = "music_utils_api_errors_total"
+	APIRateLimited     = "music_utils_api_rate_limited_total"
+	Runs               = "music_utils_runs_total"
+	RunDuration        = "music_utils_run_duration_seconds"
+	RunTimestamp       = "music_utils_last_run_timestamp_seconds"
+)
+
+const (
+	typeCounter = "counter"
+	typeGauge   = "gauge"
+)
+
+type family struct {
+	help   string
+	typ    string
+	series map[string]float64
+}
+
+var (
+	mu       sync.Mutex
+	families = map[string]*family{
+		PlaylistsProcessed: {help: "Playlists processed by command.", typ: typeCounter},
+		TracksMatched:      {help: "Tracks found on the target by target and strategy.", typ: typeCounter},
+		TracksMissing:      {help: "Tracks not found on the target by target.", typ: typeCounter},
+		LidarrAlbums:       {help: "Wanted Lidarr albums by result.", typ: typeCounter},
+		APIRequests:        {help: "HTTP requests by provider, retries included.", typ: typeCounter},
+		APIErrors:          {help: "Failed HTTP requests by provider, network errors and error responses.", typ: typeCounter},
+		APIRateLimited:     {help: "Rate limited (429) HTTP responses by provider.", typ: typeCounter},
+		Runs:               {help: "Command runs by command and status.", typ: typeCounter},
+		RunDuration:        {help: "Duration of the last run by command.", typ: typeGauge},
+		RunTimestamp:       {help: "Unix time the last run finished by command.", typ: typeGauge},
+	}
+)
+
+// Inc adds one to a counter. labels are name and value pairs.
+func Inc(name string, labels ...string) {
+	Add(name, 1, labels...)
+}
+
+// Add adds v to a counter.
+func Add(name string, v float64, labels ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	f := lookup(name)
+	f.series[labelString(labels)] += v
+}
+
+// Set sets a gauge.
+func Set(name string, v float64, labels ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	f := lookup(name)
+	f.series[labelString(labels)] = v
+}
+
+// ObserveRun records a finished run of a command.
+func ObserveRun(command string, started time.Time, err error) {
+	status := "success"
+	if err != nil {
+		status = "failed"
+	}
+	Inc(Runs, "command", command, "status", status)
+	Set(RunDuration, time.Since(started).Seconds(), "command", command)
+	Set(RunTimestamp, float64(time.Now().Unix()), "command", command)
+}
+
+func lookup(name string) *family {
+	f, ok := families[name]
+	if !ok {
+		panic(fmt.Sprintf("unknown metric %s", name))
+	}
+	if f.series == nil {
+		f.series = make(map[string]float64)
+	}
+	return f
+}
+
+// labelString formats name and value pairs as {name="value",...}
+func labelString(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(labels[i])
+		sb.WriteString(`="`)
+		sb.WriteString(escape(labels[i+1]))
+		sb.WriteString(`"`)
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// Write writes every metric with values in the Prometheus text format.
+func Write(w io.Writer) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		f := families[name]
+		if len(f.series) == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "# HELP %s %s\n# TYPE %s %s\n", name, f.help, name, f.typ)
+		series := make([]string, 0, len(f.series))
+		for labels := range f.series {
+			series = append(series, labels)
+		}
+		sort.Strings(series)
+		for _, labels := range series {
+			fmt.Fprintf(&sb, "%s%s %s\n", name, labels, strconv.FormatFloat(f.series[labels], 'g', -1, 64))
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Handler serves the metrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w)
+	})
+}
+
+// WriteTextfile writes the metrics to path for the node exporter textfile collector.
+// The file is replaced at once so the collector never reads a partial file.
+func WriteTextfile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".metrics-*")
+	if err != nil {
+		return fmt.Errorf("error creating metrics file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	err = Write(tmp)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing metrics file: %w", err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("error writing metrics file: %w", err)
+	}
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		return fmt.Errorf("error writing metrics file: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Transport counts the requests, errors and rate limited responses of a provider.
+type Transport struct {
+	Provider string
+	Base     http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	Inc(APIRequests, "provider", t.Provider)
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		Inc(APIErrors, "provider", t.Provider)
+		return resp, err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		Inc(APIRateLimited, "provider", t.Provider)
+	}
+	if resp.StatusCode >= 400 {
+		Inc(APIErrors, "provider", t.Provider)
+	}
+	return resp, nil
+}
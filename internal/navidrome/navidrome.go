@@ -1,7 +1,9 @@
 package navidrome
 
 import (
-	"github.com/rs/zerolog/log"
+	"fmt"
+
+	"github.com/spf13/viper"
 	"github.com/zibbp/music-utils/internal/database"
 )
 
@@ -13,8 +15,9 @@ func InitializeService() (*Service, error) {
 	// Setup database
 	db, err := database.Setup()
 	if err != nil {
-		log.Fatal().Msgf("Error initializing database: %w", err)
+		return nil, fmt.Errorf("error initializing database: %w", err)
 	}
+	db.DurationTolerance = viper.GetInt64("matcher.duration_tolerance")
 	return &Service{
 		Db: db,
 	}, nil
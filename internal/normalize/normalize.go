@@ -0,0 +1,96 @@
+package normalize
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// replacer maps typographic variants and letters without a Unicode decomposition to plain ASCII.
+var replacer = strings.NewReplacer(
+	// Quotes
+	"‘", "'", "’", "'", "‚", "'", "‛", "'", "′", "'", "`", "'", "´", "'",
+	"“", "\"", "”", "\"", "„", "\"", "‟", "\"", "″", "\"",
+	// Dashes
+	"‐", "-", "‑", "-", "‒", "-", "–", "-", "—", "-", "―", "-", "−", "-",
+	// Letters that NFKD does not decompose
+	"ß", "ss", "æ", "ae", "œ", "oe", "ø", "o", "ł", "l", "đ", "d", "ð", "d", "þ", "th", "ı", "i",
+)
+
+var (
+	// Credits in brackets, e.g. "(feat. X)", "[ft. X]" or "(with X)"
+	bracketCreditRegex = regexp.MustCompile(`\s*[\(\[]\s*(?:feat\.?|ft\.?|featuring|with)\s[^\)\]]*[\)\]]`)
+	// Credits without brackets, e.g. "Song feat. X", stops at the next bracket
+	creditRegex = regexp.MustCompile(`\s(?:feat\.?|ft\.?|featuring)\s[^\(\[]*`)
+	// "&", "+" and "and" written in any way
+	andRegex = regexp.MustCompile(`\s*(?:&|\+)\s*`)
+)
+
+// Fold applies Unicode NFKD, removes diacritics, replaces smart quotes and dashes,
+// lowercases and collapses whitespace.
+func Fold(s string) string {
+	s = norm.NFKD.String(s)
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	s = strings.ToLower(b.String())
+	s = replacer.Replace(s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// StripCredits removes featured artist credits from a folded title.
+func StripCredits(s string) string {
+	s = bracketCreditRegex.ReplaceAllString(s, "")
+	s = creditRegex.ReplaceAllString(s, " ")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// BaseTitle cuts everything after the first bracket or " - " of a folded title,
+// which drops suffixes like "(Live)", "[Deluxe]" or "- Remastered 2011".
+func BaseTitle(s string) string {
+	cut := len(s)
+	for _, sep := range []string{" (", " [", " - "} {
+		if i := strings.Index(s, sep); i > 0 && i < cut {
+			cut = i
+		}
+	}
+	return strings.TrimSpace(s[:cut])
+}
+
+// Title normalizes a track or album title for comparison.
+func Title(s string) string {
+	s = BaseTitle(StripCredits(Fold(s)))
+	return clean(andRegex.ReplaceAllString(s, " and "))
+}
+
+// Artist normalizes an artist name for comparison.
+func Artist(s string) string {
+	return clean(andRegex.ReplaceAllString(Fold(s), " and "))
+}
+
+// clean drops apostrophes and turns every other character that is not a letter or number into a single space.
+func clean(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	space := false
+	for _, r := range s {
+		switch {
+		case r == '\'':
+			continue
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			b.WriteRune(r)
+			space = false
+		case !space:
+			b.WriteRune(' ')
+			space = true
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
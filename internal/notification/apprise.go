@@ -0,0 +1,28 @@
+package notification
+
+import (
+	"context"
+	"strings"
+)
+
+type AppriseRequestBody struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	Type  string `json:"type"`
+	Tag   string `json:"tag,omitempty"`
+}
+
+// apprise sends the message to the notify endpoint of an Apprise API server,
+// e.g. http://apprise:8000/notify/<key>.
+type apprise struct {
+	url  string
+	tags []string
+}
+
+func (a apprise) Notify(ctx context.Context, event Event, message string) error {
+	body := AppriseRequestBody{Title: event.Title(), Body: message, Type: "success", Tag: strings.Join(a.tags, ",")}
+	if event.Failed() {
+		body.Type = "failure"
+	}
+	return postJSON(ctx, a.url, body, nil)
+}
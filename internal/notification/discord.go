@@ -0,0 +1,52 @@
+package notification
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// Discord embed colors
+const (
+	discordGreen = 0x2ecc71
+	discordRed   = 0xe74c3c
+)
+
+type DiscordRequestBody struct {
+	Embeds []DiscordEmbed `json:"embeds"`
+}
+
+type DiscordEmbed struct {
+	Title       string              `json:"title"`
+	Description string              `json:"description"`
+	Color       int                 `json:"color"`
+	Fields      []DiscordEmbedField `json:"fields,omitempty"`
+	Timestamp   string              `json:"timestamp"`
+}
+
+type DiscordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// discord posts the message as an embed to a Discord webhook.
+type discord struct {
+	url string
+}
+
+func (d discord) Notify(ctx context.Context, event Event, message string) error {
+	embed := DiscordEmbed{
+		Title:       event.Title(),
+		Description: message,
+		Color:       discordGreen,
+		Timestamp:   event.Finished.Format(time.RFC3339),
+	}
+	if event.Failed() {
+		embed.Color = discordRed
+	}
+	for _, field := range event.Fields() {
+		embed.Fields = append(embed.Fields, DiscordEmbedField{Name: field.Name, Value: strconv.Itoa(field.Value), Inline: true})
+	}
+	return postJSON(ctx, d.url, DiscordRequestBody{Embeds: []DiscordEmbed{embed}}, nil)
+}
@@ -0,0 +1,155 @@
+package notification
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/zibbp/music-utils/internal/report"
+)
+
+// DefaultTemplate renders the message when no template is configured.
+const DefaultTemplate = `{{if eq .Status "failed"}}Music-Utils {{.Command}} failed after {{.Duration}}: {{.Error}}.
+{{- else}}Music-Utils {{.Summary}} in {{.Duration}}.{{end}}
+{{- if .Playlists}} {{.Playlists}} playlists synced, {{.TracksAdded}} tracks added, {{.TracksMissing}} tracks missing.{{end}}
+{{- if .AlbumsWanted}} Lidarr: {{.AlbumsFound}} of {{.AlbumsWanted}} wanted albums found on Tidal.{{end}}
+{{- if .Errors}} {{len .Errors}} errors, the first: {{index .Errors 0}}{{end}}`
+
+// Event describes a finished run, it is the data of the message template.
+type Event struct {
+	// Command is the command or the daemon job
+	Command string `json:"command"`
+	// Summary describes what a successful run did, e.g. "imported playlists to Tidal"
+	Summary  string        `json:"summary"`
+	Status   string        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	DryRun   bool          `json:"dry_run"`
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+	Duration time.Duration `json:"duration"`
+
+	Playlists     int `json:"playlists"`
+	TracksMatched int `json:"tracks_matched"`
+	TracksAdded   int `json:"tracks_added"`
+	TracksMissing int `json:"tracks_missing"`
+	TracksFailed  int `json:"tracks_failed"`
+	TracksRemoved int `json:"tracks_removed"`
+	AlbumsWanted  int `json:"albums_wanted"`
+	AlbumsFound   int `json:"albums_found"`
+	AlbumsMissing int `json:"albums_missing"`
+	// Errors are the errors that did not stop the run
+	Errors []string `json:"errors,omitempty"`
+	// Reports are the finished reports of the run, one per command
+	Reports []*report.Report `json:"reports,omitempty"`
+}
+
+// Field is a count shown next to the message by services that support it.
+type Field struct {
+	Name  string
+	Value int
+}
+
+// NewEvent sums the finished reports of a run that started at started and failed with err, if err is set.
+func NewEvent(command string, summary string, started time.Time, err error, reports ...*report.Report) Event {
+	event := Event{
+		Command:  command,
+		Summary:  summary,
+		Status:   report.StatusSuccess,
+		Started:  started,
+		Finished: time.Now(),
+	}
+	event.Duration = event.Finished.Sub(started).Round(time.Second)
+	if err != nil {
+		event.Status, event.Error = report.StatusFailed, err.Error()
+	}
+	for _, r := range reports {
+		if r == nil {
+			continue
+		}
+		event.Reports = append(event.Reports, r)
+		event.DryRun = event.DryRun || r.DryRun
+		event.Playlists += r.Totals.Playlists
+		event.TracksMatched += r.Totals.Matched
+		event.TracksAdded += r.Totals.Added
+		event.TracksMissing += r.Totals.Missing
+		event.TracksFailed += r.Totals.Failed
+		event.TracksRemoved += r.Totals.Removed
+		if r.Albums != nil {
+			event.AlbumsWanted += r.Albums.Wanted
+			event.AlbumsFound += r.Albums.Found
+			event.AlbumsMissing += r.Albums.Missing
+		}
+		event.Errors = append(event.Errors, r.Errors...)
+		for _, playlist := range r.Playlists {
+			for _, playlistErr := range playlist.Errors {
+				event.Errors = append(event.Errors, fmt.Sprintf("%s: %s", playlist.Name, playlistErr))
+			}
+		}
+	}
+	return event
+}
+
+// Failed reports whether the run failed.
+func (e Event) Failed() bool {
+	return e.Status == report.StatusFailed
+}
+
+// Title is the title of the notification, for services that show one.
+func (e Event) Title() string {
+	title := fmt.Sprintf("Music-Utils %s succeeded", e.Command)
+	if e.Failed() {
+		title = fmt.Sprintf("Music-Utils %s failed", e.Command)
+	}
+	if e.DryRun {
+		title += " (dry run)"
+	}
+	return title
+}
+
+// Fields returns the counts that apply to the run.
+func (e Event) Fields() []Field {
+	var fields []Field
+	if e.Playlists > 0 {
+		fields = append(fields, Field{"Playlists", e.Playlists}, Field{"Tracks added", e.TracksAdded}, Field{"Tracks missing", e.TracksMissing})
+		if e.TracksRemoved > 0 {
+			fields = append(fields, Field{"Tracks removed", e.TracksRemoved})
+		}
+		if e.TracksFailed > 0 {
+			fields = append(fields, Field{"Tracks failed", e.TracksFailed})
+		}
+	}
+	if e.AlbumsWanted > 0 {
+		fields = append(fields, Field{"Albums found", e.AlbumsFound}, Field{"Albums missing", e.AlbumsMissing})
+	}
+	if len(e.Errors) > 0 {
+		fields = append(fields, Field{"Errors", len(e.Errors)})
+	}
+	return fields
+}
+
+// Render executes the message template. The template is read from templateFile when it is set,
+// otherwise text is used, and DefaultTemplate when both are empty.
+func (e Event) Render(text string, templateFile string) (string, error) {
+	if templateFile != "" {
+		data, err := os.ReadFile(templateFile)
+		if err != nil {
+			return "", fmt.Errorf("error reading notification template: %w", err)
+		}
+		text = string(data)
+	}
+	if text == "" {
+		text = DefaultTemplate
+	}
+	tmpl, err := template.New("notification").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("error parsing notification template: %w", err)
+	}
+	var buffer bytes.Buffer
+	err = tmpl.Execute(&buffer, e)
+	if err != nil {
+		return "", fmt.Errorf("error rendering notification template: %w", err)
+	}
+	return buffer.String(), nil
+}
@@ -0,0 +1,27 @@
+package notification
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type GotifyRequestBody struct {
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	Priority int    `json:"priority,omitempty"`
+}
+
+// gotify sends the message to the message endpoint of a Gotify server.
+type gotify struct {
+	url      string
+	token    string
+	priority int
+}
+
+func (g gotify) Notify(ctx context.Context, event Event, message string) error {
+	header := http.Header{}
+	header.Set("X-Gotify-Key", g.token)
+	body := GotifyRequestBody{Title: event.Title(), Message: message, Priority: g.priority}
+	return postJSON(ctx, strings.TrimSuffix(g.url, "/")+"/message", body, header)
+}
@@ -0,0 +1,20 @@
+package notification
+
+import (
+	"context"
+)
+
+type JSONRequestBody struct {
+	Title   string `json:"title"`
+	Message string `json:"message"`
+	Event   Event  `json:"event"`
+}
+
+// jsonNotifier posts the message together with the whole event.
+type jsonNotifier struct {
+	url string
+}
+
+func (j jsonNotifier) Notify(ctx context.Context, event Event, message string) error {
+	return postJSON(ctx, j.url, JSONRequestBody{Title: event.Title(), Message: message, Event: event}, nil)
+}
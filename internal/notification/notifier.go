@@ -0,0 +1,172 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/zibbp/music-utils/internal/metrics"
+)
+
+// Target types
+const (
+	TypeWebhook = "webhook"
+	TypeJSON    = "json"
+	TypeDiscord = "discord"
+	TypeSlack   = "slack"
+	TypeGotify  = "gotify"
+	TypeNtfy    = "ntfy"
+	TypeApprise = "apprise"
+)
+
+// Events a target can be notified of
+const (
+	EventSuccess = "success"
+	EventFailure = "failure"
+	// EventMissing is a run with more missing tracks than the MissingAbove of the target
+	EventMissing = "missing"
+)
+
+var client = &http.Client{Timeout: 30 * time.Second, Transport: &metrics.Transport{Provider: "notification"}}
+
+// Notifier delivers a rendered message to a notification service.
+type Notifier interface {
+	Notify(ctx context.Context, event Event, message string) error
+}
+
+// Target is a notification service from the config.
+type Target struct {
+	Name string
+	Type string
+	URL  string
+	// Token is the Gotify application token or the ntfy access token
+	Token    string
+	Priority int
+	// Tags are ntfy tags or Apprise tags
+	Tags []string
+	// Events the target is notified of, success and failure when empty
+	Events       []string
+	MissingAbove int `mapstructure:"missing_above"`
+	Template     string
+	TemplateFile string `mapstructure:"template_file"`
+}
+
+// Targets reads the targets from notification.targets. A notification.webhook.url is added as a webhook target.
+func Targets() ([]Target, error) {
+	var targets []Target
+	err := viper.UnmarshalKey("notification.targets", &targets)
+	if err != nil {
+		return nil, fmt.Errorf("error reading notification targets: %w", err)
+	}
+	if url := viper.GetString("notification.webhook.url"); url != "" {
+		targets = append(targets, Target{
+			Name:         TypeWebhook,
+			Type:         TypeWebhook,
+			URL:          url,
+			Template:     viper.GetString("notification.webhook.template"),
+			TemplateFile: viper.GetString("notification.webhook.template_file"),
+		})
+	}
+	for i := range targets {
+		if targets[i].Name == "" {
+			targets[i].Name = targets[i].Type
+		}
+		if targets[i].URL == "" {
+			return nil, fmt.Errorf("notification target %s has no url", targets[i].Name)
+		}
+		if _, err := targets[i].Notifier(); err != nil {
+			return nil, err
+		}
+		for _, event := range targets[i].Events {
+			if event != EventSuccess && event != EventFailure && event != EventMissing {
+				return nil, fmt.Errorf("notification target %s has unknown event %s", targets[i].Name, event)
+			}
+		}
+	}
+	return targets, nil
+}
+
+// Notifier returns the notifier for the type of the target.
+func (t Target) Notifier() (Notifier, error) {
+	switch t.Type {
+	case TypeWebhook:
+		return webhook{url: t.URL}, nil
+	case TypeJSON:
+		return jsonNotifier{url: t.URL}, nil
+	case TypeDiscord:
+		return discord{url: t.URL}, nil
+	case TypeSlack:
+		return slack{url: t.URL}, nil
+	case TypeGotify:
+		return gotify{url: t.URL, token: t.Token, priority: t.Priority}, nil
+	case TypeNtfy:
+		return ntfy{url: t.URL, token: t.Token, priority: t.Priority, tags: t.Tags}, nil
+	case TypeApprise:
+		return apprise{url: t.URL, tags: t.Tags}, nil
+	}
+	return nil, fmt.Errorf("notification target %s has unknown type %q", t.Name, t.Type)
+}
+
+// Wants reports whether the target is notified of the event.
+func (t Target) Wants(event Event) bool {
+	events := t.Events
+	if len(events) == 0 {
+		events = []string{EventSuccess, EventFailure}
+	}
+	return (!event.Failed() && slices.Contains(events, EventSuccess)) ||
+		(event.Failed() && slices.Contains(events, EventFailure)) ||
+		(slices.Contains(events, EventMissing) && event.TracksMissing > t.MissingAbove)
+}
+
+// Render renders the message of the event with the template of the target.
+func (t Target) Render(event Event) (string, error) {
+	return event.Render(t.Template, t.TemplateFile)
+}
+
+// Send delivers the rendered message to the target.
+func (t Target) Send(ctx context.Context, event Event, message string) error {
+	notifier, err := t.Notifier()
+	if err != nil {
+		return err
+	}
+	return notifier.Notify(ctx, event, message)
+}
+
+// postJSON posts the payload as JSON.
+func postJSON(ctx context.Context, url string, payload interface{}, header http.Header) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error marshalling notification: %w", err)
+	}
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Content-Type", "application/json")
+	return post(ctx, url, body, header)
+}
+
+func post(ctx context.Context, url string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating notification request: %w", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending notification: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("notification returned status %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package notification
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ntfy publishes the message to a ntfy topic, the url includes the topic.
+type ntfy struct {
+	url      string
+	token    string
+	priority int
+	tags     []string
+}
+
+func (n ntfy) Notify(ctx context.Context, event Event, message string) error {
+	header := http.Header{}
+	header.Set("Title", event.Title())
+	if n.token != "" {
+		header.Set("Authorization", "Bearer "+n.token)
+	}
+	if n.priority > 0 {
+		header.Set("Priority", strconv.Itoa(n.priority))
+	}
+	tags := n.tags
+	if event.Failed() {
+		tags = append([]string{"warning"}, tags...)
+	}
+	if len(tags) > 0 {
+		header.Set("Tags", strings.Join(tags, ","))
+	}
+	return post(ctx, n.url, []byte(message), header)
+}
@@ -0,0 +1,45 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+)
+
+type SlackRequestBody struct {
+	Text   string       `json:"text"`
+	Blocks []SlackBlock `json:"blocks"`
+}
+
+type SlackBlock struct {
+	Type   string      `json:"type"`
+	Text   *SlackText  `json:"text,omitempty"`
+	Fields []SlackText `json:"fields,omitempty"`
+}
+
+type SlackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// slack posts the message as blocks to a Slack incoming webhook.
+type slack struct {
+	url string
+}
+
+func (s slack) Notify(ctx context.Context, event Event, message string) error {
+	blocks := []SlackBlock{
+		{Type: "header", Text: &SlackText{Type: "plain_text", Text: event.Title()}},
+		{Type: "section", Text: &SlackText{Type: "mrkdwn", Text: message}},
+	}
+	var fields []SlackText
+	for _, field := range event.Fields() {
+		fields = append(fields, SlackText{Type: "mrkdwn", Text: fmt.Sprintf("*%s*\n%d", field.Name, field.Value)})
+	}
+	// A section holds at most 10 fields
+	for len(fields) > 0 {
+		n := min(len(fields), 10)
+		blocks = append(blocks, SlackBlock{Type: "section", Fields: fields[:n]})
+		fields = fields[n:]
+	}
+	return postJSON(ctx, s.url, SlackRequestBody{Text: message, Blocks: blocks}, nil)
+}
@@ -0,0 +1,112 @@
+package overrides
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zibbp/music-utils/internal/file"
+)
+
+const Path = "/data/overrides.json"
+
+// isrcRegex matches an ISRC such as USRC17607839, in any case
+var isrcRegex = regexp.MustCompile(`^[A-Za-z]{2}[A-Za-z0-9]{3}[0-9]{7}$`)
+
+// Overrides are manual matches that take precedence over searching.
+// A nil *Overrides is valid and contains no overrides.
+type Overrides struct {
+	// Tidal maps a Spotify track ID or ISRC to a Tidal track ID
+	Tidal map[string]int64 `json:"tidal"`
+	// Navidrome maps a Tidal track ID to a Navidrome file path
+	Navidrome map[string]string `json:"navidrome"`
+}
+
+// Load reads the overrides file, a missing file results in empty overrides.
+func Load(path string) (*Overrides, error) {
+	o := &Overrides{
+		Tidal:     map[string]int64{},
+		Navidrome: map[string]string{},
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.Debug().Msgf("No overrides file found at %s", path)
+			return o, nil
+		}
+		return nil, fmt.Errorf("error reading overrides file: %w", err)
+	}
+	err = json.Unmarshal(data, o)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling overrides file: %w", err)
+	}
+	// ISRCs are looked up in upper case
+	tidal := make(map[string]int64, len(o.Tidal))
+	for key, id := range o.Tidal {
+		tidal[tidalKey(key)] = id
+	}
+	o.Tidal = tidal
+	if o.Navidrome == nil {
+		o.Navidrome = map[string]string{}
+	}
+	log.Debug().Msgf("Loaded %d Tidal and %d Navidrome overrides", len(o.Tidal), len(o.Navidrome))
+	return o, nil
+}
+
+// TidalTrack returns the Tidal track ID overriding a Spotify track, looked up by Spotify ID then ISRC.
+func (o *Overrides) TidalTrack(spotifyID, isrc string) (int64, bool) {
+	if o == nil {
+		return 0, false
+	}
+	if id, ok := o.Tidal[spotifyID]; ok && spotifyID != "" {
+		return id, true
+	}
+	if id, ok := o.Tidal[strings.ToUpper(isrc)]; ok && isrc != "" {
+		return id, true
+	}
+	return 0, false
+}
+
+// NavidromePath returns the Navidrome file path overriding a Tidal track.
+func (o *Overrides) NavidromePath(tidalID int64) (string, bool) {
+	if o == nil {
+		return "", false
+	}
+	path, ok := o.Navidrome[strconv.FormatInt(tidalID, 10)]
+	return path, ok
+}
+
+// SetTidalTrack overrides a Spotify track ID or ISRC with a Tidal track ID.
+func (o *Overrides) SetTidalTrack(key string, tidalID int64) {
+	o.Tidal[tidalKey(key)] = tidalID
+}
+
+// tidalKey upper-cases ISRCs, Spotify IDs are case sensitive and kept as they are.
+func tidalKey(key string) string {
+	if isrcRegex.MatchString(key) {
+		return strings.ToUpper(key)
+	}
+	return key
+}
+
+// SetNavidromePath overrides a Tidal track with a Navidrome file path.
+func (o *Overrides) SetNavidromePath(tidalID int64, path string) {
+	o.Navidrome[strconv.FormatInt(tidalID, 10)] = path
+}
+
+func (o *Overrides) Save(path string) error {
+	data, err := file.JSONMarshal(o)
+	if err != nil {
+		return fmt.Errorf("error marshalling overrides: %w", err)
+	}
+	err = file.WriteFile(path, data)
+	if err != nil {
+		return fmt.Errorf("error writing overrides file: %w", err)
+	}
+	return nil
+}
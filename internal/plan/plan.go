@@ -0,0 +1,103 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Action types
+const (
+	ActionCreatePlaylist   = "create_playlist"
+	ActionAddTracks        = "add_tracks"
+	ActionRemoveTracks     = "remove_tracks"
+	ActionMoveTrack        = "move_track"
+	ActionWriteM3U8        = "write_m3u8"
+	ActionWriteFile        = "write_file"
+	ActionSendNotification = "send_notification"
+)
+
+var descriptions = map[string]string{
+	ActionCreatePlaylist:   "Create playlist",
+	ActionAddTracks:        "Add tracks to",
+	ActionRemoveTracks:     "Remove tracks from",
+	ActionMoveTrack:        "Move track in",
+	ActionWriteM3U8:        "Write m3u8 lines to",
+	ActionWriteFile:        "Write file",
+	ActionSendNotification: "Send notification to",
+}
+
+// Action is a change that a dry run did not make.
+type Action struct {
+	Type   string   `json:"type"`
+	Target string   `json:"target"`
+	Items  []string `json:"items,omitempty"`
+}
+
+// Plan records the actions of a dry run. A nil Plan means changes are made.
+type Plan struct {
+	mu      sync.Mutex
+	Actions []Action `json:"actions"`
+}
+
+func New() *Plan {
+	return &Plan{Actions: []Action{}}
+}
+
+// Record adds an action to the plan.
+func (p *Plan) Record(actionType string, target string, items ...string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Actions = append(p.Actions, Action{Type: actionType, Target: target, Items: items})
+}
+
+// Summary writes the planned actions in a readable form.
+func (p *Plan) Summary(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.Actions) == 0 {
+		_, err := fmt.Fprintln(w, "Dry run: nothing to change")
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "Dry run: %d planned actions\n", len(p.Actions))
+	if err != nil {
+		return err
+	}
+	for _, action := range p.Actions {
+		description, ok := descriptions[action.Type]
+		if !ok {
+			description = action.Type
+		}
+		line := fmt.Sprintf("  %s %s", description, action.Target)
+		if len(action.Items) > 0 {
+			line = fmt.Sprintf("%s (%d)", line, len(action.Items))
+		}
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+		for _, item := range action.Items {
+			_, err := fmt.Fprintf(w, "    %s\n", item)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// JSON writes the planned actions as JSON.
+func (p *Plan) JSON(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p)
+}
@@ -0,0 +1,95 @@
+package report
+
+import (
+	"html/template"
+	"time"
+)
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"time": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04:05 MST")
+	},
+	"duration": func(started, finished time.Time) string {
+		return finished.Sub(started).Round(time.Second).String()
+	},
+	"percent": func(count, total int) int {
+		if total == 0 {
+			return 0
+		}
+		return count * 100 / total
+	},
+	"total": func(buckets []Bucket) int {
+		total := 0
+		for _, bucket := range buckets {
+			total += bucket.Count
+		}
+		return total
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>music-utils {{.Command}} {{time .Started}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+.failed { color: #b00; }
+.bar { display: inline-block; height: 0.8em; background: #4a8; }
+.histogram td { border: none; padding: 0.1em 0.4em; }
+</style>
+</head>
+<body>
+<h1>music-utils {{.Command}}</h1>
+<p>
+Started {{time .Started}}, took {{duration .Started .Finished}}{{if .DryRun}}, dry run{{end}}.
+Status: <strong{{if eq .Status "failed"}} class="failed"{{end}}>{{.Status}}</strong>
+{{- if .Error}}: <span class="failed">{{.Error}}</span>{{end}}
+</p>
+{{with .Albums}}
+<h2>Lidarr</h2>
+<table>
+<tr><th>Wanted</th><th>Found</th><th>Missing</th></tr>
+<tr><td>{{.Wanted}}</td><td>{{.Found}}</td><td>{{.Missing}}</td></tr>
+</table>
+{{end}}
+{{if .Playlists}}
+<h2>Playlists</h2>
+<table>
+<tr><th>Playlist</th><th>Target</th><th>Source</th><th>Matched</th><th>Added</th><th>Present</th><th>Missing</th><th>Failed</th><th>Removed</th><th>Errors</th></tr>
+{{range .Playlists}}
+<tr><td>{{.Name}}</td><td>{{.Target}}</td><td>{{.Source}}</td><td>{{.Matched}}</td><td>{{.Added}}</td><td>{{.Present}}</td><td>{{.Missing}}</td><td>{{.Failed}}</td><td>{{.Removed}}</td><td>{{len .Errors}}</td></tr>
+{{end}}
+{{with .Totals}}
+<tr><th>Total</th><th>{{.Playlists}}</th><th>{{.Source}}</th><th>{{.Matched}}</th><th>{{.Added}}</th><th>{{.Present}}</th><th>{{.Missing}}</th><th>{{.Failed}}</th><th>{{.Removed}}</th><th>{{.Errors}}</th></tr>
+{{end}}
+</table>
+{{range .Playlists}}
+{{if or .Confidence .MissingConfidence .Errors}}
+<h3>{{.Name}}</h3>
+{{with .Confidence}}{{$total := total .}}
+<p>Confidence of matched tracks</p>
+<table class="histogram">
+{{range .}}<tr><td>{{printf "%.1f" .Min}} - {{printf "%.1f" .Max}}</td><td>{{.Count}}</td><td><span class="bar" style="width: {{percent .Count $total}}px"></span></td></tr>
+{{end}}</table>
+{{end}}
+{{with .MissingConfidence}}{{$total := total .}}
+<p>Confidence of the best candidate of missing tracks</p>
+<table class="histogram">
+{{range .}}<tr><td>{{printf "%.1f" .Min}} - {{printf "%.1f" .Max}}</td><td>{{.Count}}</td><td><span class="bar" style="width: {{percent .Count $total}}px"></span></td></tr>
+{{end}}</table>
+{{end}}
+{{with .Errors}}
+<ul class="failed">{{range .}}<li>{{.}}</li>{{end}}</ul>
+{{end}}
+{{end}}
+{{end}}
+{{end}}
+{{with .Errors}}
+<h2>Errors</h2>
+<ul class="failed">{{range .}}<li>{{.}}</li>{{end}}</ul>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,227 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zibbp/music-utils/internal/file"
+)
+
+// Dir is the directory reports are written to
+const Dir = "/data/reports"
+
+// Run statuses
+const (
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+)
+
+// Playlist targets
+const (
+	TargetSpotify   = "spotify"
+	TargetTidal     = "tidal"
+	TargetNavidrome = "navidrome"
+)
+
+// buckets is the number of confidence histogram buckets
+const buckets = 10
+
+// Report describes a single command run. A nil Report records nothing.
+type Report struct {
+	mu        sync.Mutex
+	Command   string     `json:"command"`
+	Started   time.Time  `json:"started"`
+	Finished  time.Time  `json:"finished"`
+	DryRun    bool       `json:"dry_run"`
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	Totals    Totals     `json:"totals"`
+	Playlists []Playlist `json:"playlists"`
+	Albums    *Albums    `json:"albums,omitempty"`
+	Errors    []string   `json:"errors,omitempty"`
+}
+
+// Totals sums the counts of every playlist.
+type Totals struct {
+	Playlists int `json:"playlists"`
+	Source    int `json:"source"`
+	Matched   int `json:"matched"`
+	Added     int `json:"added"`
+	Present   int `json:"present"`
+	Missing   int `json:"missing"`
+	Failed    int `json:"failed"`
+	Removed   int `json:"removed"`
+	Errors    int `json:"errors"`
+}
+
+// Playlist holds the counts of one playlist. Matched is Added, Present and Failed together.
+type Playlist struct {
+	Name    string `json:"name"`
+	Target  string `json:"target"`
+	Source  int    `json:"source"`
+	Matched int    `json:"matched"`
+	Added   int    `json:"added"`
+	Present int    `json:"present"`
+	Missing int    `json:"missing"`
+	Failed  int    `json:"failed"`
+	Removed int    `json:"removed"`
+	// Confidence is the histogram of matched tracks, MissingConfidence of the best candidate of missing tracks
+	Confidence        []Bucket `json:"confidence,omitempty"`
+	MissingConfidence []Bucket `json:"missing_confidence,omitempty"`
+	Errors            []string `json:"errors,omitempty"`
+}
+
+// Bucket counts the tracks with a confidence from Min up to Max.
+type Bucket struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int     `json:"count"`
+}
+
+// Albums holds the counts of the wanted Lidarr albums.
+type Albums struct {
+	Wanted  int `json:"wanted"`
+	Found   int `json:"found"`
+	Missing int `json:"missing"`
+}
+
+func New(command string, dryRun bool) *Report {
+	return &Report{Command: command, Started: time.Now(), DryRun: dryRun, Playlists: []Playlist{}}
+}
+
+// MatchedPlaylist builds the counts of a playlist from its matched tracks.
+func MatchedPlaylist(name string, target string, matchedTracks []file.MatchedTrack) Playlist {
+	playlist := Playlist{Name: name, Target: target, Source: len(matchedTracks)}
+	var matched, missing []float64
+	for _, matchedTrack := range matchedTracks {
+		switch matchedTrack.Status {
+		case file.MatchStatusAdded:
+			playlist.Added++
+		case file.MatchStatusInPlaylist:
+			playlist.Present++
+		case file.MatchStatusFailed:
+			playlist.Failed++
+		case file.MatchStatusError:
+			// Counted in the errors of the playlist
+			continue
+		case file.MatchStatusMissing:
+			playlist.Missing++
+			if matchedTrack.TidalID != "" {
+				missing = append(missing, matchedTrack.Confidence)
+			}
+			continue
+		}
+		matched = append(matched, matchedTrack.Confidence)
+	}
+	playlist.Matched = playlist.Added + playlist.Present + playlist.Failed
+	playlist.Confidence = Histogram(matched)
+	playlist.MissingConfidence = Histogram(missing)
+	return playlist
+}
+
+// Histogram counts confidences in buckets of 0.1, a confidence of 1 goes in the last bucket.
+func Histogram(confidences []float64) []Bucket {
+	if len(confidences) == 0 {
+		return nil
+	}
+	histogram := make([]Bucket, buckets)
+	for i := range histogram {
+		histogram[i].Min = float64(i) / buckets
+		histogram[i].Max = float64(i+1) / buckets
+	}
+	for _, confidence := range confidences {
+		i := int(confidence * buckets)
+		i = max(0, min(i, buckets-1))
+		histogram[i].Count++
+	}
+	return histogram
+}
+
+// AddPlaylist adds the counts of a playlist.
+func (r *Report) AddPlaylist(playlist Playlist) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Playlists = append(r.Playlists, playlist)
+}
+
+// SetAlbums sets the counts of the wanted Lidarr albums.
+func (r *Report) SetAlbums(albums Albums) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Albums = &albums
+}
+
+// AddError records an error that does not belong to a playlist.
+func (r *Report) AddError(format string, args ...interface{}) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+// Finish sets the status of the run and sums the playlists.
+func (r *Report) Finish(err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Finished = time.Now()
+	r.Status = StatusSuccess
+	if err != nil {
+		r.Status, r.Error = StatusFailed, err.Error()
+	}
+	r.Totals = Totals{Playlists: len(r.Playlists), Errors: len(r.Errors)}
+	for _, playlist := range r.Playlists {
+		r.Totals.Source += playlist.Source
+		r.Totals.Matched += playlist.Matched
+		r.Totals.Added += playlist.Added
+		r.Totals.Present += playlist.Present
+		r.Totals.Missing += playlist.Missing
+		r.Totals.Failed += playlist.Failed
+		r.Totals.Removed += playlist.Removed
+		r.Totals.Errors += len(playlist.Errors)
+	}
+}
+
+// Write writes the report to Dir as JSON, and as HTML next to it when html is set. It returns the path of the JSON file.
+// Reports describe the run and are written during a dry run as well.
+func (r *Report) Write(html bool) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := fmt.Sprintf("%s-%s", r.Started.UTC().Format("20060102T150405Z"), strings.ReplaceAll(r.Command, " ", "-"))
+	data, err := file.JSONMarshal(r)
+	if err != nil {
+		return "", fmt.Errorf("error marshalling report: %w", err)
+	}
+	path := fmt.Sprintf("%s/%s.json", Dir, name)
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		return "", fmt.Errorf("error writing report: %w", err)
+	}
+	if html {
+		var buffer bytes.Buffer
+		err = htmlTemplate.Execute(&buffer, r)
+		if err != nil {
+			return "", fmt.Errorf("error rendering report: %w", err)
+		}
+		err = os.WriteFile(fmt.Sprintf("%s/%s.html", Dir, name), buffer.Bytes(), 0644)
+		if err != nil {
+			return "", fmt.Errorf("error writing report: %w", err)
+		}
+	}
+	return path, nil
+}
@@ -0,0 +1,253 @@
+package review
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zibbp/music-utils/internal/database"
+	"github.com/zibbp/music-utils/internal/file"
+	"github.com/zibbp/music-utils/internal/matcher"
+	"github.com/zibbp/music-utils/internal/overrides"
+	"github.com/zibbp/music-utils/internal/tidal"
+)
+
+// Reviewer walks through missing and low confidence matches in the terminal
+// and saves the chosen matches as overrides.
+type Reviewer struct {
+	in            *bufio.Scanner
+	out           io.Writer
+	matcher       *matcher.Matcher
+	overrides     *overrides.Overrides
+	overridesPath string
+	// Candidates is the number of candidates shown per track
+	Candidates int
+	// Threshold is the confidence below which a matched track is reviewed
+	Threshold float64
+}
+
+type action int
+
+const (
+	actionSkip action = iota
+	actionAccept
+	actionQuit
+)
+
+func New(in io.Reader, out io.Writer, trackMatcher *matcher.Matcher, trackOverrides *overrides.Overrides, overridesPath string, candidates int, threshold float64) *Reviewer {
+	if candidates <= 0 {
+		candidates = 5
+	}
+	return &Reviewer{
+		in:            bufio.NewScanner(in),
+		out:           out,
+		matcher:       trackMatcher,
+		overrides:     trackOverrides,
+		overridesPath: overridesPath,
+		Candidates:    candidates,
+		Threshold:     threshold,
+	}
+}
+
+// TidalTracks returns the unique matched tracks that are missing or below the review threshold
+// and don't have an override yet.
+func (r *Reviewer) TidalTracks(tracks []file.MatchedTrack) []file.MatchedTrack {
+	seen := make(map[string]bool)
+	var review []file.MatchedTrack
+	for _, track := range tracks {
+		if seen[track.SpotifyID] {
+			continue
+		}
+		seen[track.SpotifyID] = true
+		if _, ok := r.overrides.TidalTrack(track.SpotifyID, track.ISRC); ok {
+			continue
+		}
+		// The lookup failed, the next run searches for the track again
+		if track.Status == file.MatchStatusError {
+			continue
+		}
+		if track.Status == file.MatchStatusMissing || track.Confidence < r.Threshold {
+			review = append(review, track)
+		}
+	}
+	return review
+}
+
+// NavidromeTracks returns the unique missing Navidrome tracks that don't have an override yet.
+func (r *Reviewer) NavidromeTracks(tracks []file.MissingTrackNavidrome) []file.MissingTrackNavidrome {
+	seen := make(map[int64]bool)
+	var review []file.MissingTrackNavidrome
+	for _, track := range tracks {
+		if seen[track.ID] {
+			continue
+		}
+		seen[track.ID] = true
+		if _, ok := r.overrides.NavidromePath(track.ID); ok {
+			continue
+		}
+		review = append(review, track)
+	}
+	return review
+}
+
+// Tidal reviews Spotify tracks against Tidal search results.
+func (r *Reviewer) Tidal(ctx context.Context, tidalService *tidal.Service, tracks []file.MatchedTrack) error {
+	for i, track := range tracks {
+		source := matcher.Track{
+			ID:       track.SpotifyID,
+			Title:    track.Name,
+			Artists:  track.Artists,
+			Album:    track.Album,
+			ISRC:     track.ISRC,
+			Duration: track.Duration,
+		}
+		fmt.Fprintf(r.out, "\n[%d/%d] %s\n", i+1, len(tracks), formatTrack(source))
+		if track.TidalID != "" {
+			fmt.Fprintf(r.out, "  Current match: %s - %s (confidence %.2f)\n", track.TidalTitle, strings.Join(track.TidalArtists, ", "), track.Confidence)
+		}
+
+		query := track.Name
+		if len(track.Artists) > 0 {
+			query = fmt.Sprintf("%s %s", track.Name, track.Artists[0])
+		}
+		search, err := tidalService.SearchTracks(ctx, query)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error searching for track %s", track.Name)
+		}
+		var candidates []matcher.Track
+		for _, item := range search.Tracks.Items {
+			candidates = append(candidates, matcher.FromTidalTrack(item))
+		}
+
+		act, choice := r.choose(source, candidates, "Tidal track ID")
+		switch act {
+		case actionQuit:
+			return nil
+		case actionSkip:
+			continue
+		}
+		tidalID, err := strconv.ParseInt(choice, 10, 64)
+		if err != nil {
+			fmt.Fprintf(r.out, "  Invalid Tidal track ID %q, skipping\n", choice)
+			continue
+		}
+		key := track.SpotifyID
+		if key == "" {
+			key = strings.ToUpper(track.ISRC)
+		}
+		r.overrides.SetTidalTrack(key, tidalID)
+		err = r.overrides.Save(r.overridesPath)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(r.out, "  Saved override %s -> %d\n", key, tidalID)
+	}
+	return nil
+}
+
+// Navidrome reviews Tidal tracks against media files in the Navidrome database.
+func (r *Reviewer) Navidrome(db *database.Database, tracks []file.MissingTrackNavidrome) error {
+	for i, track := range tracks {
+		source := matcher.Track{
+			ID:       strconv.FormatInt(track.ID, 10),
+			Title:    track.Name,
+			Album:    track.Album,
+			Duration: track.Duration,
+		}
+		for _, artist := range track.Artists {
+			source.Artists = append(source.Artists, artist.Name)
+		}
+		fmt.Fprintf(r.out, "\n[%d/%d] %s\n", i+1, len(tracks), formatTrack(source))
+
+		var artist string
+		if len(source.Artists) > 0 {
+			artist = source.Artists[0]
+		}
+		mediaFiles, err := db.SearchTracks(strings.Split(track.Name, " (")[0], artist, r.Candidates*4)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error searching for track %s", track.Name)
+		}
+		var candidates []matcher.Track
+		for _, mediaFile := range mediaFiles {
+			candidates = append(candidates, matcher.FromMediaFile(mediaFile))
+		}
+
+		act, path := r.choose(source, candidates, "Navidrome file path")
+		switch act {
+		case actionQuit:
+			return nil
+		case actionSkip:
+			continue
+		}
+		r.overrides.SetNavidromePath(track.ID, path)
+		err = r.overrides.Save(r.overridesPath)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(r.out, "  Saved override %d -> %s\n", track.ID, path)
+	}
+	return nil
+}
+
+// choose prints the ranked candidates and reads the user's decision.
+// For an accepted candidate the candidate ID or the manually entered value is returned.
+func (r *Reviewer) choose(source matcher.Track, candidates []matcher.Track, manualLabel string) (action, string) {
+	results := r.matcher.Rank(source, candidates)
+	if len(results) > r.Candidates {
+		results = results[:r.Candidates]
+	}
+	if len(results) == 0 {
+		fmt.Fprintln(r.out, "  No candidates found")
+	}
+	for i, result := range results {
+		fmt.Fprintf(r.out, "  %d) %.2f  %s  [%s]\n", i+1, result.Confidence, formatTrack(result.Candidate), result.Candidate.ID)
+	}
+
+	for {
+		fmt.Fprintf(r.out, "Select a candidate, (m)anual, (s)kip or (q)uit [s]: ")
+		input, ok := r.readLine()
+		if !ok {
+			return actionQuit, ""
+		}
+		switch strings.ToLower(input) {
+		case "", "s":
+			return actionSkip, ""
+		case "q":
+			return actionQuit, ""
+		case "m":
+			fmt.Fprintf(r.out, "%s: ", manualLabel)
+			value, ok := r.readLine()
+			if !ok {
+				return actionQuit, ""
+			}
+			if value == "" {
+				return actionSkip, ""
+			}
+			return actionAccept, value
+		}
+		n, err := strconv.Atoi(input)
+		if err == nil && n >= 1 && n <= len(results) {
+			return actionAccept, results[n-1].Candidate.ID
+		}
+		fmt.Fprintf(r.out, "  Invalid choice %q\n", input)
+	}
+}
+
+func (r *Reviewer) readLine() (string, bool) {
+	if !r.in.Scan() {
+		return "", false
+	}
+	return strings.TrimSpace(r.in.Text()), true
+}
+
+func formatTrack(track matcher.Track) string {
+	return fmt.Sprintf("%s - %s (%s, %s)", track.Title, strings.Join(track.Artists, ", "), track.Album, formatDuration(track.Duration))
+}
+
+func formatDuration(seconds int64) string {
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
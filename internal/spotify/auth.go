@@ -2,32 +2,61 @@ package spotify
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"sync/atomic"
 
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
+	"github.com/zibbp/music-utils/internal/metrics"
 	"github.com/zmb3/spotify/v2"
 	spotifyauth "github.com/zmb3/spotify/v2/auth"
 	"golang.org/x/oauth2"
 )
 
+// ErrNotAuthorized is returned by InitializeService when there are no tokens and login is not allowed.
+var ErrNotAuthorized = errors.New("spotify is not authorized, run auth spotify")
+
 var (
 	ch    = make(chan *spotify.Client)
 	state = "music-utils"
+	// callbackRegistered is set when another server handles the callback
+	callbackRegistered atomic.Bool
+	// scopes are requested at login, the modify scopes are needed by spotify import
+	scopes = []string{
+		spotifyauth.ScopeUserReadPrivate,
+		spotifyauth.ScopePlaylistReadPrivate,
+		spotifyauth.ScopePlaylistModifyPublic,
+		spotifyauth.ScopePlaylistModifyPrivate,
+	}
 )
 
-func authFlow() (*Service, error) {
+// clientContext makes the OAuth clients created with ctx count their requests
+func clientContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: &metrics.Transport{Provider: "spotify"}})
+}
+
+// RegisterCallback serves the OAuth callback on mux, logins then don't start their own server.
+func RegisterCallback(mux *http.ServeMux) {
+	mux.HandleFunc("/callback", completeAuth)
+	callbackRegistered.Store(true)
+}
+
+func authFlow(ctx context.Context, login bool) (*Service, error) {
 	// Ensure Spotify application ID and secret are set
 	if viper.GetString("spotify.client_id") == "" || viper.GetString("spotify.client_secret") == "" {
-		log.Fatal().Msg("Spotify client ID and/or secret not set")
+		return nil, fmt.Errorf("spotify client ID and/or secret not set")
 	}
 
 	// Check if Spotify access and refresh token is set
 	// If set, fetch and return client
 	if viper.GetString("spotify.access_token") == "" || viper.GetString("spotify.refresh_token") == "" {
+		if !login {
+			return nil, ErrNotAuthorized
+		}
 		log.Warn().Msg("Spotify access token and refresh token not set")
-		client, err := auth()
+		client, err := auth(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("error authenticating with Spotify: %w", err)
 		}
@@ -46,9 +75,9 @@ func authFlow() (*Service, error) {
 	spotClientID := viper.GetString("spotify.client_id")
 	spotClientSecret := viper.GetString("spotify.client_secret")
 	redirectURI := viper.GetString("spotify.redirect_uri")
-	auth := spotifyauth.New(spotifyauth.WithClientID(spotClientID), spotifyauth.WithClientSecret(spotClientSecret), spotifyauth.WithRedirectURL(redirectURI), spotifyauth.WithScopes(spotifyauth.ScopeUserReadPrivate, spotifyauth.ScopePlaylistReadPrivate))
+	auth := spotifyauth.New(spotifyauth.WithClientID(spotClientID), spotifyauth.WithClientSecret(spotClientSecret), spotifyauth.WithRedirectURL(redirectURI), spotifyauth.WithScopes(scopes...))
 
-	client := spotify.New(auth.Client(context.Background(), tok))
+	client := spotify.New(auth.Client(clientContext(context.Background()), tok), spotify.WithRetry(true))
 
 	newTok, _ := client.Token()
 	viper.Set("spotify.access_token", newTok.AccessToken)
@@ -68,26 +97,34 @@ func authFlow() (*Service, error) {
 	return &Service{client: client}, nil
 }
 
-func auth() (*spotify.Client, error) {
+func auth(ctx context.Context) (*spotify.Client, error) {
 	spotClientID := viper.GetString("spotify.client_id")
 	spotClientSecret := viper.GetString("spotify.client_secret")
 	redirectURI := viper.GetString("spotify.redirect_uri")
-	auth := spotifyauth.New(spotifyauth.WithClientID(spotClientID), spotifyauth.WithClientSecret(spotClientSecret), spotifyauth.WithRedirectURL(redirectURI), spotifyauth.WithScopes(spotifyauth.ScopeUserReadPrivate, spotifyauth.ScopePlaylistReadPrivate))
-	// Start an HTTP server
-	http.HandleFunc("/callback", completeAuth)
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
-	go func() {
-		err := http.ListenAndServe(":28542", nil)
-		if err != nil {
-			log.Error().Msgf("Error starting HTTP server: %w", err)
-		}
-	}()
+	auth := spotifyauth.New(spotifyauth.WithClientID(spotClientID), spotifyauth.WithClientSecret(spotClientSecret), spotifyauth.WithRedirectURL(redirectURI), spotifyauth.WithScopes(scopes...))
+	// Start an HTTP server, unless the callback is already served
+	if !callbackRegistered.Load() {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/callback", completeAuth)
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+		go func() {
+			err := http.ListenAndServe(":28542", mux)
+			if err != nil {
+				log.Error().Err(err).Msg("Error starting HTTP server")
+			}
+		}()
+	}
 
 	url := auth.AuthURL(state)
 	log.Info().Msgf("Please log in to Spotify by visiting the following page in your browser: %s", url)
 
 	// wait for auth to complete
-	client := <-ch
+	var client *spotify.Client
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case client = <-ch:
+	}
 
 	// use the client to make calls that require authorization
 	user, err := client.CurrentUser(context.Background())
@@ -102,16 +139,18 @@ func completeAuth(w http.ResponseWriter, r *http.Request) {
 	spotClientID := viper.GetString("spotify.client_id")
 	spotClientSecret := viper.GetString("spotify.client_secret")
 	redirectURI := viper.GetString("spotify.redirect_uri")
-	auth := spotifyauth.New(spotifyauth.WithClientID(spotClientID), spotifyauth.WithClientSecret(spotClientSecret), spotifyauth.WithRedirectURL(redirectURI), spotifyauth.WithScopes(spotifyauth.ScopeUserReadPrivate, spotifyauth.ScopePlaylistReadPrivate))
+	auth := spotifyauth.New(spotifyauth.WithClientID(spotClientID), spotifyauth.WithClientSecret(spotClientSecret), spotifyauth.WithRedirectURL(redirectURI), spotifyauth.WithScopes(scopes...))
 
-	tok, err := auth.Token(r.Context(), state, r)
+	tok, err := auth.Token(clientContext(r.Context()), state, r)
 	if err != nil {
 		http.Error(w, "Couldn't get token", http.StatusForbidden)
-		log.Error().Msgf("Couldn't get token: %w", err)
+		log.Error().Err(err).Msg("Couldn't get token")
+		return
 	}
 	if st := r.FormValue("state"); st != state {
 		http.NotFound(w, r)
 		log.Error().Msgf("State mismatch: %s != %s\n", st, state)
+		return
 	}
 
 	// Save token to config
@@ -121,10 +160,14 @@ func completeAuth(w http.ResponseWriter, r *http.Request) {
 	viper.Set("spotify.token_type", tok.TokenType)
 	err = viper.WriteConfig()
 	if err != nil {
-		log.Error().Msgf("Error writing config file: %w", err)
+		log.Error().Err(err).Msg("Error writing config file")
 	}
 
 	// use the token to get an authenticated client
-	client := spotify.New(auth.Client(r.Context(), tok))
-	ch <- client
+	client := spotify.New(auth.Client(clientContext(context.Background()), tok), spotify.WithRetry(true))
+	// Only a login in progress waits for the client, a callback without one must not block
+	select {
+	case ch <- client:
+	default:
+	}
 }
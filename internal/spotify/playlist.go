@@ -0,0 +1,74 @@
+package spotify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"github.com/zibbp/music-utils/internal/plan"
+	"github.com/zmb3/spotify/v2"
+)
+
+// addChunkSize is the most tracks Spotify adds to a playlist in one request
+const addChunkSize = 100
+
+// plannedPrefix starts the ID of a playlist created during a dry run
+const plannedPrefix = "planned-"
+
+// SearchTracks searches Spotify for tracks. A query of isrc:<isrc> finds the tracks with that ISRC.
+func (s *Service) SearchTracks(ctx context.Context, query string) ([]spotify.FullTrack, error) {
+	result, err := s.client.Search(ctx, query, spotify.SearchTypeTrack, spotify.Limit(viper.GetInt("spotify.search_results")))
+	if err != nil {
+		return nil, fmt.Errorf("error searching spotify: %w", err)
+	}
+	if result.Tracks == nil {
+		return nil, nil
+	}
+	return result.Tracks.Tracks, nil
+}
+
+// CreatePlaylist creates a private playlist for the user. During a dry run a planned playlist is returned instead.
+func (s *Service) CreatePlaylist(ctx context.Context, userID string, name string, description string) (*spotify.FullPlaylist, error) {
+	log.Debug().Msgf("Creating Spotify playlist %s", name)
+	if s.Plan != nil {
+		s.Plan.Record(plan.ActionCreatePlaylist, name)
+		s.planned++
+		playlist := &spotify.FullPlaylist{}
+		playlist.ID = spotify.ID(fmt.Sprintf("%s%d", plannedPrefix, s.planned))
+		playlist.Name = name
+		playlist.Description = description
+		return playlist, nil
+	}
+	playlist, err := s.client.CreatePlaylistForUser(ctx, userID, name, description, false, false)
+	if err != nil {
+		return nil, fmt.Errorf("error creating spotify playlist: %w", err)
+	}
+	return playlist, nil
+}
+
+// AddTracksToPlaylist adds the tracks to the playlist in chunks. During a dry run the tracks are recorded in the plan.
+func (s *Service) AddTracksToPlaylist(ctx context.Context, playlist spotify.SimplePlaylist, trackIDs []spotify.ID) error {
+	if s.Plan != nil {
+		items := make([]string, len(trackIDs))
+		for i, id := range trackIDs {
+			items[i] = id.String()
+		}
+		s.Plan.Record(plan.ActionAddTracks, playlist.Name, items...)
+		return nil
+	}
+	for start := 0; start < len(trackIDs); start += addChunkSize {
+		end := min(start+addChunkSize, len(trackIDs))
+		_, err := s.client.AddTracksToPlaylist(ctx, playlist.ID, trackIDs[start:end]...)
+		if err != nil {
+			return fmt.Errorf("error adding tracks %d to %d: %w", start+1, end, err)
+		}
+	}
+	return nil
+}
+
+// IsPlanned reports whether the playlist was created during a dry run and does not exist.
+func IsPlanned(id spotify.ID) bool {
+	return strings.HasPrefix(id.String(), plannedPrefix)
+}
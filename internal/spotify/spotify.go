@@ -6,34 +6,56 @@ import (
 
 	"github.com/rs/zerolog/log"
 	"github.com/zibbp/music-utils/internal/file"
+	"github.com/zibbp/music-utils/internal/filter"
+	"github.com/zibbp/music-utils/internal/metrics"
+	"github.com/zibbp/music-utils/internal/plan"
 	"github.com/zmb3/spotify/v2"
 )
 
 type Service struct {
 	client *spotify.Client
+	// Plan records the changes instead of making them when set
+	Plan *plan.Plan
+	// planned counts the playlists created during a dry run
+	planned int
 }
 
-func InitializeService() (*Service, error) {
-	s, err := authFlow()
+// InitializeService creates the service with the saved tokens. Without tokens the login is started
+// when login is set, otherwise ErrNotAuthorized is returned.
+func InitializeService(ctx context.Context, login bool) (*Service, error) {
+	s, err := authFlow(ctx, login)
 	if err != nil {
 		return nil, err
 	}
 	return s, nil
 }
 
-func (s *Service) SaveUserPlaylists() error {
+// SaveUserPlaylists writes the playlists allowed by the filter to files and returns them.
+func (s *Service) SaveUserPlaylists(playlistFilter *filter.Filter) ([]*spotify.FullPlaylist, error) {
 	// Fetch playlists
 	playlists, err := s.GetUserSimplePlaylists()
 	if err != nil {
-		return fmt.Errorf("error getting user playlists: %w", err)
+		return nil, fmt.Errorf("error getting user playlists: %w", err)
 	}
+	var userID string
+	if playlistFilter != nil && playlistFilter.OwnedOnly {
+		userID, err = s.CurrentUserID()
+		if err != nil {
+			return nil, fmt.Errorf("error getting current user: %w", err)
+		}
+	}
+	var saved []*spotify.FullPlaylist
 	// Get more playlist information
 	for _, playlist := range playlists {
+		if !playlistFilter.Spotify(playlist.Name, playlist.Owner.ID, userID) {
+			log.Debug().Msgf("Skipping playlist: %s as it is filtered out", playlist.Name)
+			continue
+		}
 
 		// Get full playlist
 		fullPlaylist, err := s.GetPlaylist(playlist.ID)
 		if err != nil {
-			return fmt.Errorf("error getting playlist: %w", err)
+			return saved, fmt.Errorf("error getting playlist: %w", err)
 		}
 
 		if fullPlaylist.Name == "" {
@@ -44,7 +66,7 @@ func (s *Service) SaveUserPlaylists() error {
 		// Fetch playlist tracks
 		tracks, err := s.GetPlaylistTracks(playlist.ID)
 		if err != nil {
-			return fmt.Errorf("error getting playlist tracks: %w", err)
+			return saved, fmt.Errorf("error getting playlist tracks: %w", err)
 		}
 
 		// FullTrack to PlaylistTrack
@@ -59,18 +81,30 @@ func (s *Service) SaveUserPlaylists() error {
 		// Write playlist to file
 		err = file.WritePlaylistToFile(fullPlaylist)
 		if err != nil {
-			return fmt.Errorf("error writing playlist to file: %w", err)
+			return saved, fmt.Errorf("error writing playlist to file: %w", err)
 		}
 
 		log.Info().Msgf("Saved playlist: %s", fullPlaylist.Name)
+		if s.Plan == nil {
+			metrics.Inc(metrics.PlaylistsProcessed, "command", "spotify save")
+		}
+		saved = append(saved, fullPlaylist)
+	}
+	return saved, nil
+}
+
+func (s *Service) CurrentUserID() (string, error) {
+	user, err := s.client.CurrentUser(context.Background())
+	if err != nil {
+		return "", err
 	}
-	return nil
+	return user.ID, nil
 }
 
 func (s *Service) GetUserSimplePlaylists() ([]spotify.SimplePlaylist, error) {
 	simplePlaylists, err := s.client.CurrentUsersPlaylists(context.Background())
 	if err != nil {
-		log.Error().Msgf("Error getting users playlists: %w", err)
+		log.Error().Err(err).Msg("Error getting users playlists")
 		return nil, err
 	}
 	var allSimplePlaylists []spotify.SimplePlaylist
@@ -85,7 +119,7 @@ func (s *Service) GetUserSimplePlaylists() ([]spotify.SimplePlaylist, error) {
 				break
 			}
 			if err != nil {
-				log.Error().Msgf("Error getting user playlists: %w", err)
+				return nil, fmt.Errorf("error getting user playlists page %d: %w", page+1, err)
 			}
 		}
 	}
@@ -118,7 +152,7 @@ func (s *Service) GetPlaylistTracks(id spotify.ID) ([]*spotify.FullTrack, error)
 				break
 			}
 			if err != nil {
-				log.Error().Msgf("Error getting playlist tracks: %w", err)
+				return nil, fmt.Errorf("error getting playlist tracks page %d: %w", page+1, err)
 			}
 		}
 	}
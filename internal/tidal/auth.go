@@ -1,9 +1,9 @@
 package tidal
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"strings"
@@ -96,11 +96,9 @@ type Client struct {
 	AuthorizedForOfflineDate interface{} `json:"authorizedForOfflineDate"`
 }
 
-func getDeviceCode() (DeviceCode, error) {
+func (s *Service) getDeviceCode(ctx context.Context) (DeviceCode, error) {
 	var deviceCode DeviceCode
 
-	client := &http.Client{}
-
 	// Set body
 	data := url.Values{}
 	data.Set("client_id", clientId)
@@ -108,30 +106,25 @@ func getDeviceCode() (DeviceCode, error) {
 
 	encodedData := data.Encode()
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/device_authorization", authURL), strings.NewReader(encodedData))
-	if err != nil {
-		return deviceCode, err
-	}
-
-	// Set headers
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, body, err := s.transport.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/device_authorization", authURL), strings.NewReader(encodedData))
+		if err != nil {
+			return nil, err
+		}
 
-	resp, err := client.Do(req)
+		// Set headers
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	})
 	if err != nil {
 		return deviceCode, err
 	}
 
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+	err = statusError(resp, body)
 	if err != nil {
 		return deviceCode, err
 	}
 
-	if resp.StatusCode != 200 {
-		return deviceCode, fmt.Errorf("%s", string(body))
-	}
-
 	err = json.Unmarshal(body, &deviceCode)
 	if err != nil {
 		return deviceCode, err
@@ -141,10 +134,9 @@ func getDeviceCode() (DeviceCode, error) {
 
 }
 
-func tokenLogin(deviceCode DeviceCode) (LoginResponse, error) {
+func (s *Service) tokenLogin(ctx context.Context, deviceCode DeviceCode) (LoginResponse, error) {
 	var loginResponse LoginResponse
 
-	client := &http.Client{}
 	// Set body
 	data := url.Values{}
 	data.Set("client_id", clientId)
@@ -154,24 +146,18 @@ func tokenLogin(deviceCode DeviceCode) (LoginResponse, error) {
 
 	encodedData := data.Encode()
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/token", authURL), strings.NewReader(encodedData))
-	if err != nil {
-		return LoginResponse{}, err
-	}
-
-	req.SetBasicAuth(clientId, clientSecret)
-
-	// Set Headers
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return LoginResponse{}, err
-	}
+	resp, body, err := s.transport.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/token", authURL), strings.NewReader(encodedData))
+		if err != nil {
+			return nil, err
+		}
 
-	defer resp.Body.Close()
+		req.SetBasicAuth(clientId, clientSecret)
 
-	body, err := io.ReadAll(resp.Body)
+		// Set Headers
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	})
 	if err != nil {
 		return LoginResponse{}, err
 	}
@@ -196,35 +182,28 @@ func tokenLogin(deviceCode DeviceCode) (LoginResponse, error) {
 
 }
 
-func checkSession(accessToken string) (Session, error) {
+func (s *Service) checkSession(ctx context.Context, accessToken string) (Session, error) {
 	var session Session
 
-	client := &http.Client{}
-
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/sessions", apiURL), nil)
-	if err != nil {
-		return Session{}, err
-	}
-
-	// Set Headers
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	resp, body, err := s.transport.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/sessions", apiURL), nil)
+		if err != nil {
+			return nil, err
+		}
 
-	resp, err := client.Do(req)
+		// Set Headers
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+		return req, nil
+	})
 	if err != nil {
 		return Session{}, err
 	}
 
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+	err = statusError(resp, body)
 	if err != nil {
 		return Session{}, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return Session{}, fmt.Errorf("%s", string(body))
-	}
-
 	err = json.Unmarshal(body, &session)
 	if err != nil {
 		return Session{}, err
@@ -233,10 +212,9 @@ func checkSession(accessToken string) (Session, error) {
 	return session, nil
 }
 
-func refreshAccessToken(refreshToken string) (Refresh, error) {
+func (s *Service) refreshAccessToken(ctx context.Context, refreshToken string) (Refresh, error) {
 	var refresh Refresh
 
-	client := &http.Client{}
 	// Set body
 	data := url.Values{}
 	data.Set("client_id", clientId)
@@ -246,32 +224,27 @@ func refreshAccessToken(refreshToken string) (Refresh, error) {
 
 	encodedData := data.Encode()
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/token", authURL), strings.NewReader(encodedData))
-	if err != nil {
-		return Refresh{}, err
-	}
-
-	req.SetBasicAuth(clientId, clientSecret)
+	resp, body, err := s.transport.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/token", authURL), strings.NewReader(encodedData))
+		if err != nil {
+			return nil, err
+		}
 
-	// Set Headers
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(clientId, clientSecret)
 
-	resp, err := client.Do(req)
+		// Set Headers
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	})
 	if err != nil {
 		return Refresh{}, err
 	}
 
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+	err = statusError(resp, body)
 	if err != nil {
 		return Refresh{}, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return Refresh{}, fmt.Errorf("%s", string(body))
-	}
-
 	err = json.Unmarshal(body, &refresh)
 	if err != nil {
 		return Refresh{}, err
@@ -0,0 +1,202 @@
+package tidal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"github.com/zibbp/music-utils/internal/metrics"
+)
+
+const (
+	defaultRequestsPerSecond = 5
+	defaultMaxRetries        = 5
+	defaultTimeout           = 30 * time.Second
+	baseBackoff              = 500 * time.Millisecond
+	maxBackoff               = 30 * time.Second
+)
+
+// transport is the HTTP client shared by every Tidal request. It throttles requests with a
+// token bucket and retries rate limited, failed and transient server error responses. Writes are
+// only retried when they were rate limited or could not be sent, so they are never applied twice.
+type transport struct {
+	client     *http.Client
+	limiter    *rateLimiter
+	maxRetries int
+}
+
+func newTransport() *transport {
+	requestsPerSecond := viper.GetFloat64("tidal.requests_per_second")
+	if requestsPerSecond <= 0 {
+		requestsPerSecond = defaultRequestsPerSecond
+	}
+	maxRetries := viper.GetInt("tidal.max_retries")
+	if maxRetries < 0 {
+		maxRetries = defaultMaxRetries
+	}
+	timeout := time.Duration(viper.GetInt("tidal.timeout_seconds")) * time.Second
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &transport{
+		client:     &http.Client{Timeout: timeout, Transport: &metrics.Transport{Provider: "tidal"}},
+		limiter:    newRateLimiter(requestsPerSecond, max(1, int(requestsPerSecond))),
+		maxRetries: maxRetries,
+	}
+}
+
+// do sends the request built by newRequest, rebuilding it for every attempt. The response body
+// is read and closed, the response is returned for its status code and headers.
+func (t *transport) do(ctx context.Context, newRequest func(ctx context.Context) (*http.Request, error)) (*http.Response, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		err := t.limiter.Wait(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		req, err := newRequest(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		resp, err := t.client.Do(req)
+		var body []byte
+		if err == nil {
+			body, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+
+		var wait time.Duration
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			if attempt >= t.maxRetries || (!idempotent(req) && !notSent(err)) {
+				return nil, nil, err
+			}
+			wait = backoff(attempt)
+			log.Debug().Err(err).Msgf("Tidal request to %s failed, retrying in %s", req.URL.Path, wait)
+		case resp.StatusCode == http.StatusTooManyRequests || (resp.StatusCode >= http.StatusInternalServerError && idempotent(req)):
+			if attempt >= t.maxRetries {
+				return resp, body, nil
+			}
+			wait = retryAfter(resp.Header.Get("Retry-After"))
+			if wait == 0 {
+				wait = backoff(attempt)
+			}
+			if resp.StatusCode == http.StatusTooManyRequests {
+				log.Warn().Msgf("Tidal rate limit reached, retrying in %s", wait)
+			} else {
+				log.Debug().Msgf("Tidal request to %s returned %d, retrying in %s", req.URL.Path, resp.StatusCode, wait)
+			}
+		default:
+			return resp, body, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// idempotent reports whether the request can be sent again after it may have been applied.
+func idempotent(req *http.Request) bool {
+	return req.Method == http.MethodGet || req.Method == http.MethodHead
+}
+
+// notSent reports whether the request failed before it was sent, because no connection could be made.
+func notSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// backoff returns an exponential delay with full jitter for the given attempt.
+func backoff(attempt int) time.Duration {
+	d := baseBackoff << attempt
+	if d > maxBackoff || d <= 0 {
+		d = maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func retryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// rateLimiter is a token bucket refilled at rate tokens per second up to burst tokens.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until a token is available or the context is done.
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// statusError returns an error containing the response body if the status code is not 200.
+func statusError(resp *http.Response, body []byte) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if len(body) == 0 {
+		return errors.New(resp.Status)
+	}
+	return fmt.Errorf("%s", string(body))
+}
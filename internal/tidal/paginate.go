@@ -0,0 +1,61 @@
+package tidal
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+const defaultPageSize = 100
+
+// page is the envelope Tidal wraps every paginated list in.
+type page[T any] struct {
+	Limit              int64 `json:"limit"`
+	Offset             int64 `json:"offset"`
+	TotalNumberOfItems int64 `json:"totalNumberOfItems"`
+	Items              []T   `json:"items"`
+}
+
+// paginate follows offset and limit of a list endpoint until totalNumberOfItems or max items are fetched.
+// A max of 0 fetches every item. decode extracts the page from a response body. Tidal leaves unavailable
+// items out of a page, so the offset advances by the requested limit rather than by the items received.
+func paginate[T any](ctx context.Context, s *Service, reqUrl string, params url.Values, max int, decode func([]byte) (page[T], error)) ([]T, int64, error) {
+	pageSize := s.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+
+	var items []T
+	var total int64
+	offset := 0
+	for {
+		limit := pageSize
+		if max > 0 && max-len(items) < limit {
+			limit = max - len(items)
+		}
+
+		q := url.Values{}
+		for key, values := range params {
+			q[key] = values
+		}
+		q.Set("offset", strconv.Itoa(offset))
+		q.Set("limit", strconv.Itoa(limit))
+
+		body, err := s.standardHttpGetRequest(ctx, reqUrl, q)
+		if err != nil {
+			return nil, 0, err
+		}
+		p, err := decode(body)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		items = append(items, p.Items...)
+		total = p.TotalNumberOfItems
+		offset += limit
+		if len(p.Items) == 0 || int64(offset) >= total || (max > 0 && len(items) >= max) {
+			break
+		}
+	}
+	return items, total, nil
+}
@@ -1,22 +1,26 @@
 package tidal
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
-	"io"
+	"github.com/zibbp/music-utils/internal/plan"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 )
 
 const (
-	apiURL      = "https://listen.tidal.com/v1"
-	apiURL2     = "https://listen.tidal.com/v2"
-	countryCode = "US"
+	apiURL              = "https://listen.tidal.com/v1"
+	apiURL2             = "https://listen.tidal.com/v2"
+	countryCode         = "US"
+	defaultAddChunkSize = 100
 )
 
 type Service struct {
@@ -25,6 +29,19 @@ type Service struct {
 	RefreshToken string
 	ClientID     string
 	ClientSecret string
+	// PageSize is the number of items requested per page from list endpoints
+	PageSize int
+	// SearchResults is the maximum number of search results fetched
+	SearchResults int
+	// AddChunkSize is the number of tracks added to a playlist per request
+	AddChunkSize int
+	// Plan records playlist changes instead of making them when set
+	Plan      *plan.Plan
+	transport *transport
+	// planned holds the playlists created during a dry run
+	planned map[string]Playlist
+	// titles holds the titles of fetched playlists during a dry run
+	titles map[string]string
 }
 
 type CreatedPlaylist struct {
@@ -157,32 +174,43 @@ type TopHit struct {
 	Type  string `json:"type"`
 }
 
-func InitializeService() (*Service, error) {
+// ErrNotAuthorized is returned by InitializeService when there are no tokens and login is not allowed.
+var ErrNotAuthorized = errors.New("tidal is not authorized, run auth tidal")
+
+// InitializeService creates the service with the saved tokens. Without tokens the device login is started
+// when login is set, otherwise ErrNotAuthorized is returned.
+func InitializeService(ctx context.Context, login bool) (*Service, error) {
 	log.Info().Msg("Initializing Tidal service...")
 	var s Service
 	s.ClientID = clientId
 	s.ClientSecret = clientSecret
+	s.PageSize = viper.GetInt("tidal.page_size")
+	s.SearchResults = viper.GetInt("tidal.search_results")
+	s.AddChunkSize = viper.GetInt("tidal.add_chunk_size")
+	s.transport = newTransport()
 
 	if viper.GetString("tidal.access_token") == "" || viper.GetString("tidal.refresh_token") == "" {
+		if !login {
+			return nil, ErrNotAuthorized
+		}
 		log.Debug().Msg("No Tidal access token or refresh token found in config, attempting to get new tokens...")
-		deviceCode, err := getDeviceCode()
+		deviceCode, err := s.getDeviceCode(ctx)
 		if err != nil {
-			log.Fatal().Err(err).Msg("Error getting device code")
-			return nil, err
+			return nil, fmt.Errorf("error getting device code: %w", err)
 		}
 		log.Info().Msgf("Please visit the following URL to authorize this application: https://%v", deviceCode.VerificationURIComplete)
 
 		// Begin polling for authorization
 		for {
-			loginResponse, err := tokenLogin(deviceCode)
+			loginResponse, err := s.tokenLogin(ctx, deviceCode)
 			if err != nil {
-				log.Fatal().Err(err).Msg("Tidal auth failed at token login")
+				return nil, fmt.Errorf("tidal auth failed at token login: %w", err)
 			}
 			if (AuthLogin{} == loginResponse.AuthLogin) {
 				// No auth token - check what errors occurred
 				// If error is expired_token, the device ID expired (5 minutes)
 				if loginResponse.AuthError.Error == "expired_token" {
-					log.Fatal().Msg("Tidal auth failed at token login - device ID expired. Please try again")
+					return nil, fmt.Errorf("tidal auth failed at token login - device ID expired. Please try again")
 				}
 			} else {
 				// Auth token received - break loop
@@ -195,34 +223,36 @@ func InitializeService() (*Service, error) {
 				viper.Set("tidal.user_id", s.UserID)
 				err := viper.WriteConfig()
 				if err != nil {
-					log.Fatal().Err(err).Msg("Error writing config file")
-					return nil, err
+					return nil, fmt.Errorf("error writing config file: %w", err)
 				}
 				break
 			}
 			d := time.Duration(deviceCode.Interval) * time.Second
 			log.Debug().Msgf("Waiting %d seconds before trying again.", deviceCode.Interval)
-			time.Sleep(d)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(d):
+			}
 		}
 	} else {
 		configAccessToken := viper.GetString("tidal.access_token")
 		configRefreshToken := viper.GetString("tidal.refresh_token")
 		// Check if access token is valid
-		session, err := checkSession(configAccessToken)
+		session, err := s.checkSession(ctx, configAccessToken)
 		if err != nil {
 			log.Info().Msg("failed to get Tidal session, attempting to refresh token")
 			// Get session failed. Access token is probably expired.
-			refresh, err := refreshAccessToken(configRefreshToken)
+			refresh, err := s.refreshAccessToken(ctx, configRefreshToken)
 			if err != nil {
 				log.Error().Msg("Tidal auth failed at refreshing access token. Please log in again.")
 				viper.Set("tidal.access_token", "")
 				viper.Set("tidal.refresh_token", "")
 				err := viper.WriteConfig()
 				if err != nil {
-					log.Fatal().Err(err).Msg("Error writing config file")
-					return nil, err
+					return nil, fmt.Errorf("error writing config file: %w", err)
 				}
-				InitializeService()
+				return InitializeService(ctx, login)
 			}
 			// Write new access token to config
 			viper.Set("tidal.access_token", refresh.AccessToken)
@@ -245,102 +275,102 @@ func InitializeService() (*Service, error) {
 
 }
 
-func (s *Service) standardHttpGetRequest(reqUrl string) ([]byte, error) {
+func (s *Service) standardHttpGetRequest(ctx context.Context, reqUrl string, params url.Values) ([]byte, error) {
 	log.Debug().Msgf("Tidal GET request: %v", reqUrl)
 
-	client := &http.Client{}
-
-	req, err := http.NewRequest("GET", reqUrl, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	// Set Headers
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.AccessToken))
+	resp, body, err := s.transport.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", reqUrl, nil)
+		if err != nil {
+			return nil, err
+		}
 
-	// Set Query Params
-	q := url.Values{}
-	q.Add("countryCode", countryCode)
-	q.Add("limit", "10000")
+		// Set Headers
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.AccessToken))
 
-	req.URL.RawQuery = q.Encode()
+		// Set Query Params
+		q := url.Values{}
+		q.Add("countryCode", countryCode)
+		for key, values := range params {
+			for _, value := range values {
+				q.Add(key, value)
+			}
+		}
 
-	resp, err := client.Do(req)
+		req.URL.RawQuery = q.Encode()
+		return req, nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+	err = statusError(resp, body)
 	if err != nil {
 		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("%s", string(body))
-	}
-
 	return body, nil
 }
 
-func (s *Service) GetUserPlaylists() (UserPlaylists, error) {
+func (s *Service) GetUserPlaylists(ctx context.Context) (UserPlaylists, error) {
 	log.Debug().Msgf("Getting playlists for Tidal user %s", s.UserID)
-	playlists, err := s.standardHttpGetRequest(fmt.Sprintf("%s/users/%s/playlists", apiURL, s.UserID))
-	if err != nil {
-		return UserPlaylists{}, err
-	}
-
-	var tidalUserPlaylists UserPlaylists
-	err = json.Unmarshal(playlists, &tidalUserPlaylists)
+	playlists, total, err := paginate(ctx, s, fmt.Sprintf("%s/users/%s/playlists", apiURL, s.UserID), nil, 0, func(body []byte) (page[Playlist], error) {
+		var p page[Playlist]
+		err := json.Unmarshal(body, &p)
+		return p, err
+	})
 	if err != nil {
 		return UserPlaylists{}, err
 	}
 
-	return tidalUserPlaylists, nil
+	return UserPlaylists{
+		Limit:              int64(len(playlists)),
+		TotalNumberOfItems: total,
+		Items:              playlists,
+	}, nil
 
 }
 
-func (s *Service) CreatePlaylist(name string, description string) (Playlist, error) {
+func (s *Service) CreatePlaylist(ctx context.Context, name string, description string) (Playlist, error) {
 	log.Debug().Msgf("Creating playlist %s", name)
-
-	// HTTP
-	client := &http.Client{}
-
-	req, err := http.NewRequest("PUT", fmt.Sprintf("%s/my-collection/playlists/folders/create-playlist", apiURL2), nil)
-	if err != nil {
-		return Playlist{}, err
+	if s.Plan != nil {
+		s.Plan.Record(plan.ActionCreatePlaylist, name)
+		if s.planned == nil {
+			s.planned = make(map[string]Playlist)
+		}
+		playlist := Playlist{UUID: fmt.Sprintf("planned-%d", len(s.planned)+1), Title: name, Description: description}
+		s.planned[playlist.UUID] = playlist
+		return playlist, nil
 	}
 
-	// Set Headers
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.AccessToken))
+	resp, body, err := s.transport.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "PUT", fmt.Sprintf("%s/my-collection/playlists/folders/create-playlist", apiURL2), nil)
+		if err != nil {
+			return nil, err
+		}
 
-	// Set Query Params
-	q := url.Values{}
-	q.Add("folderId", "root")
-	q.Add("name", name)
-	q.Add("description", description)
+		// Set Headers
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.AccessToken))
 
-	req.URL.RawQuery = q.Encode()
+		// Set Query Params
+		q := url.Values{}
+		q.Add("folderId", "root")
+		q.Add("name", name)
+		q.Add("description", description)
 
-	resp, err := client.Do(req)
+		req.URL.RawQuery = q.Encode()
+		return req, nil
+	})
 	if err != nil {
 		return Playlist{}, err
 	}
 
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+	err = statusError(resp, body)
 	if err != nil {
 		return Playlist{}, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return Playlist{}, fmt.Errorf("%s", string(body))
-	}
-
 	var createdPlaylist CreatedPlaylist
 
 	err = json.Unmarshal(body, &createdPlaylist)
@@ -351,9 +381,12 @@ func (s *Service) CreatePlaylist(name string, description string) (Playlist, err
 	return createdPlaylist.Data, nil
 }
 
-func (s *Service) GetPlaylist(id string) (Playlist, error) {
+func (s *Service) GetPlaylist(ctx context.Context, id string) (Playlist, error) {
+	if playlist, ok := s.planned[id]; ok {
+		return playlist, nil
+	}
 
-	body, err := s.standardHttpGetRequest(fmt.Sprintf("%s/playlists/%s", apiURL, id))
+	body, err := s.standardHttpGetRequest(ctx, fmt.Sprintf("%s/playlists/%s", apiURL, id), nil)
 	if err != nil {
 		return Playlist{}, err
 	}
@@ -364,215 +397,407 @@ func (s *Service) GetPlaylist(id string) (Playlist, error) {
 		return Playlist{}, err
 	}
 
+	if s.Plan != nil {
+		if s.titles == nil {
+			s.titles = make(map[string]string)
+		}
+		s.titles[playlist.UUID] = playlist.Title
+	}
+
 	return playlist, nil
 }
 
-func (s *Service) GetPlaylistTracks(id string) (TidalPlaylistTracks, error) {
+func (s *Service) GetPlaylistTracks(ctx context.Context, id string) (TidalPlaylistTracks, error) {
 	log.Debug().Msgf("Getting playlist tracks for %s", id)
-
-	body, err := s.standardHttpGetRequest(fmt.Sprintf("%s/playlists/%s/tracks", apiURL, id))
-	if err != nil {
-		return TidalPlaylistTracks{}, err
+	if _, ok := s.planned[id]; ok {
+		return TidalPlaylistTracks{}, nil
 	}
 
-	var playlistTracks TidalPlaylistTracks
-	err = json.Unmarshal(body, &playlistTracks)
+	tracks, total, err := paginate(ctx, s, fmt.Sprintf("%s/playlists/%s/tracks", apiURL, id), nil, 0, func(body []byte) (page[Track], error) {
+		var p page[Track]
+		err := json.Unmarshal(body, &p)
+		return p, err
+	})
 	if err != nil {
 		return TidalPlaylistTracks{}, err
 	}
 
-	return playlistTracks, nil
+	return TidalPlaylistTracks{
+		Limit:              int64(len(tracks)),
+		TotalNumberOfItems: total,
+		Items:              tracks,
+	}, nil
 
 }
 
-func (s *Service) SearchTracks(query string) (TrackSearch, error) {
+func (s *Service) SearchTracks(ctx context.Context, query string) (TrackSearch, error) {
 	log.Debug().Msgf("Searching Tidal tracks for %s", query)
 
-	// HTTP
-	client := &http.Client{}
+	params := url.Values{}
+	params.Add("query", query)
+	params.Add("types", "TRACKS")
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/search", apiURL), nil)
+	var trackSearch TrackSearch
+	first := true
+	tracks, total, err := paginate(ctx, s, fmt.Sprintf("%s/search", apiURL), params, s.SearchResults, func(body []byte) (page[Track], error) {
+		var search TrackSearch
+		err := json.Unmarshal(body, &search)
+		if err != nil {
+			return page[Track]{}, err
+		}
+		// Keep everything but the tracks from the first page
+		if first {
+			trackSearch = search
+			first = false
+		}
+		return page[Track](search.Tracks), nil
+	})
 	if err != nil {
 		return TrackSearch{}, err
 	}
 
-	// Set Headers
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.AccessToken))
+	trackSearch.Tracks = SearchTracksPagination{
+		Limit:              int64(len(tracks)),
+		TotalNumberOfItems: total,
+		Items:              tracks,
+	}
+	return trackSearch, nil
 
-	// Set Query Params
-	q := url.Values{}
-	q.Add("countryCode", countryCode)
-	q.Add("limit", "20")
-	q.Add("query", query)
-	q.Add("types", "TRACKS")
+}
 
-	req.URL.RawQuery = q.Encode()
+func (s *Service) getPlaylistEtag(ctx context.Context, id string) (string, error) {
+	resp, body, err := s.transport.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/playlists/%s", apiURL, id), nil)
+		if err != nil {
+			return nil, err
+		}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return TrackSearch{}, err
-	}
+		// Set Headers
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.AccessToken))
 
-	defer resp.Body.Close()
+		// Set Query Params
+		q := url.Values{}
+		q.Add("countryCode", countryCode)
 
-	body, err := io.ReadAll(resp.Body)
+		req.URL.RawQuery = q.Encode()
+		return req, nil
+	})
 	if err != nil {
-		return TrackSearch{}, err
+		return "", err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return TrackSearch{}, fmt.Errorf("%s", string(body))
+	err = statusError(resp, body)
+	if err != nil {
+		return "", err
 	}
 
-	var trackSearch TrackSearch
+	playlistEtag := resp.Header.Get("ETag")
 
-	err = json.Unmarshal(body, &trackSearch)
-	if err != nil {
-		return TrackSearch{}, err
+	return playlistEtag, nil
+}
+
+func (s *Service) AddTrackToPlaylist(ctx context.Context, playlistId string, trackId int64) error {
+	return s.AddTracksToPlaylist(ctx, playlistId, []int64{trackId})
+}
+
+// errDuplicate is returned by addTracksChunk when Tidal rejects a chunk because a track is already in the playlist
+var errDuplicate = errors.New("track already in playlist")
+
+// AddTracksToPlaylist adds the tracks in order, in chunks of AddChunkSize tracks per request. A track given
+// more than once is added once. When a chunk holds a track that is already in the playlist, only that track is skipped.
+func (s *Service) AddTracksToPlaylist(ctx context.Context, playlistId string, trackIds []int64) error {
+	chunkSize := s.AddChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultAddChunkSize
 	}
 
-	return trackSearch, nil
+	// Remove duplicates while keeping the order
+	seen := make(map[int64]bool, len(trackIds))
+	var ids []string
+	for _, trackId := range trackIds {
+		if seen[trackId] {
+			continue
+		}
+		seen[trackId] = true
+		ids = append(ids, strconv.FormatInt(trackId, 10))
+	}
+
+	if s.Plan != nil {
+		if len(ids) > 0 {
+			s.Plan.Record(plan.ActionAddTracks, s.playlistTarget(playlistId), ids...)
+		}
+		return nil
+	}
 
+	for start := 0; start < len(ids); start += chunkSize {
+		end := min(start+chunkSize, len(ids))
+		err := s.addTracks(ctx, playlistId, ids[start:end])
+		if err != nil {
+			return fmt.Errorf("error adding tracks %d to %d: %w", start+1, end, err)
+		}
+	}
+	return nil
 }
 
-func (s *Service) getPlaylistEtag(id string) (string, error) {
-	client := &http.Client{}
+// addTracks adds a chunk of tracks. Tidal rejects the whole chunk if one of them is already in the playlist,
+// then the chunk is split in halves until only the duplicates are left, which are skipped.
+func (s *Service) addTracks(ctx context.Context, playlistId string, trackIds []string) error {
+	err := s.addTracksChunk(ctx, playlistId, trackIds)
+	if !errors.Is(err, errDuplicate) {
+		return err
+	}
+	if len(trackIds) == 1 {
+		log.Debug().Msgf("Track %s already exists in playlist %s", trackIds[0], playlistId)
+		return nil
+	}
+	half := len(trackIds) / 2
+	err = s.addTracks(ctx, playlistId, trackIds[:half])
+	if err != nil {
+		return err
+	}
+	return s.addTracks(ctx, playlistId, trackIds[half:])
+}
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/playlists/%s", apiURL, id), nil)
+func (s *Service) addTracksChunk(ctx context.Context, playlistId string, trackIds []string) error {
+	log.Debug().Msgf("Adding %d tracks to playlist %s", len(trackIds), playlistId)
+	// The ETag changes with every modification of the playlist
+	playlistEtag, err := s.getPlaylistEtag(ctx, playlistId)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	// Set Headers
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.AccessToken))
+	data := url.Values{}
+	data.Set("trackIds", strings.Join(trackIds, ","))
+	data.Set("onArtifactNotFound", "FAIL")
+	data.Set("onDupes", "FAIL")
 
-	// Set Query Params
-	q := url.Values{}
-	q.Add("countryCode", countryCode)
+	encodedData := data.Encode()
 
-	req.URL.RawQuery = q.Encode()
+	resp, body, err := s.transport.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/playlists/%s/items", apiURL, playlistId), strings.NewReader(encodedData))
+		if err != nil {
+			return nil, err
+		}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
+		// Set Headers
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.AccessToken))
+		req.Header.Set("If-None-Match", playlistEtag)
 
-	defer resp.Body.Close()
+		// Set Query Params
+		q := url.Values{}
+		q.Add("countryCode", countryCode)
 
-	body, err := io.ReadAll(resp.Body)
+		req.URL.RawQuery = q.Encode()
+		return req, nil
+	})
 	if err != nil {
-		return "", err
+		return err
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("%s", string(body))
+		if resp.StatusCode == http.StatusConflict {
+			return errDuplicate
+		}
+		return statusError(resp, body)
 	}
 
-	playlistEtag := resp.Header.Get("ETag")
-
-	return playlistEtag, nil
+	return nil
 }
 
-func (s *Service) AddTrackToPlaylist(playlistId string, trackId int64) error {
-	log.Debug().Msgf("Adding track %v to playlist %s", trackId, playlistId)
-	playlistEtag, err := s.getPlaylistEtag(playlistId)
-	if err != nil {
-		return err
+// RemovePlaylistItems removes the items at the given indexes from the playlist.
+func (s *Service) RemovePlaylistItems(ctx context.Context, playlistId string, indexes []int) error {
+	chunkSize := s.AddChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultAddChunkSize
 	}
 
-	client := &http.Client{}
+	// Remove from the end so the remaining indexes stay valid
+	sorted := slices.Clone(indexes)
+	slices.Sort(sorted)
+	slices.Reverse(sorted)
 
-	data := url.Values{}
-	data.Set("trackIds", fmt.Sprintf("%v", trackId))
-	data.Set("onArtifactNotFound", "FAIL")
-	data.Set("onDupes", "FAIL")
+	if s.Plan != nil {
+		items := make([]string, 0, len(sorted))
+		for _, index := range sorted {
+			items = append(items, fmt.Sprintf("index %d", index))
+		}
+		s.Plan.Record(plan.ActionRemoveTracks, s.playlistTarget(playlistId), items...)
+		return nil
+	}
 
-	// reqBody := fmt.Sprintf(`{"trackIds":"%v","onArtifactNotFound":"FAIL","onDupes":"FAIL"}`, trackId)
-	encodedData := data.Encode()
+	for start := 0; start < len(sorted); start += chunkSize {
+		end := min(start+chunkSize, len(sorted))
+		ids := make([]string, 0, end-start)
+		for _, index := range sorted[start:end] {
+			ids = append(ids, strconv.Itoa(index))
+		}
+		err := s.removePlaylistItemsChunk(ctx, playlistId, ids)
+		if err != nil {
+			return fmt.Errorf("error removing items %s: %w", strings.Join(ids, ","), err)
+		}
+	}
+	return nil
+}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/playlists/%s/items", apiURL, playlistId), strings.NewReader(encodedData))
+func (s *Service) removePlaylistItemsChunk(ctx context.Context, playlistId string, indexes []string) error {
+	log.Debug().Msgf("Removing %d items from playlist %s", len(indexes), playlistId)
+	playlistEtag, err := s.getPlaylistEtag(ctx, playlistId)
 	if err != nil {
 		return err
 	}
 
-	// Set Headers
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.AccessToken))
-	req.Header.Set("If-None-Match", playlistEtag)
+	resp, body, err := s.transport.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "DELETE", fmt.Sprintf("%s/playlists/%s/items/%s", apiURL, playlistId, strings.Join(indexes, ",")), nil)
+		if err != nil {
+			return nil, err
+		}
 
-	// Set Query Params
-	q := url.Values{}
-	q.Add("countryCode", countryCode)
+		// Set Headers
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.AccessToken))
+		req.Header.Set("If-None-Match", playlistEtag)
 
-	req.URL.RawQuery = q.Encode()
+		// Set Query Params
+		q := url.Values{}
+		q.Add("countryCode", countryCode)
+		q.Add("order", "INDEX")
+		q.Add("orderDirection", "ASC")
 
-	resp, err := client.Do(req)
+		req.URL.RawQuery = q.Encode()
+		return req, nil
+	})
 	if err != nil {
 		return err
 	}
 
-	defer resp.Body.Close()
+	return statusError(resp, body)
+}
 
-	body, err := io.ReadAll(resp.Body)
+// MovePlaylistItem moves the item at index from to index to.
+func (s *Service) MovePlaylistItem(ctx context.Context, playlistId string, from int, to int) error {
+	log.Debug().Msgf("Moving item %d to %d in playlist %s", from, to, playlistId)
+	if s.Plan != nil {
+		s.Plan.Record(plan.ActionMoveTrack, s.playlistTarget(playlistId), fmt.Sprintf("%d to %d", from, to))
+		return nil
+	}
+	playlistEtag, err := s.getPlaylistEtag(ctx, playlistId)
 	if err != nil {
 		return err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		if resp.StatusCode == http.StatusConflict {
-			log.Debug().Msgf("Track %v already exists in playlist %s", trackId, playlistId)
-		} else {
-			return fmt.Errorf("%s", string(body))
+	data := url.Values{}
+	data.Set("toIndex", strconv.Itoa(to))
+
+	encodedData := data.Encode()
+
+	resp, body, err := s.transport.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/playlists/%s/items/%d", apiURL, playlistId, from), strings.NewReader(encodedData))
+		if err != nil {
+			return nil, err
 		}
 
+		// Set Headers
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.AccessToken))
+		req.Header.Set("If-None-Match", playlistEtag)
+
+		// Set Query Params
+		q := url.Values{}
+		q.Add("countryCode", countryCode)
+
+		req.URL.RawQuery = q.Encode()
+		return req, nil
+	})
+	if err != nil {
 		return err
 	}
 
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp, body)
+	}
+
 	return nil
 }
 
-func (s *Service) FindAlbum(albumTitle, albumArtist string) (*TrackSearch, error) {
-	log.Debug().Msgf("Searching for album %s by %s", albumTitle, albumArtist)
-
-	// HTTP
-	client := &http.Client{}
-
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/search", apiURL), nil)
-	if err != nil {
-		return nil, err
+// ReorderPlaylist moves the tracks of the playlist into the order of trackIds.
+// Tracks that are not in trackIds keep their relative order after the ordered ones.
+// It returns the number of moves made.
+func (s *Service) ReorderPlaylist(ctx context.Context, playlistId string, tracks []Track, trackIds []int64) (int, error) {
+	current := make([]int64, len(tracks))
+	for i, track := range tracks {
+		current[i] = track.ID
 	}
 
-	// Set Headers
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.AccessToken))
+	moves := 0
+	for i, trackId := range playlistOrder(current, trackIds) {
+		if current[i] == trackId {
+			continue
+		}
+		// The track is always found after i, everything before is already in place
+		from := i + 1
+		for current[from] != trackId {
+			from++
+		}
+		err := s.MovePlaylistItem(ctx, playlistId, from, i)
+		if err != nil {
+			return moves, fmt.Errorf("error moving track %d to %d: %w", trackId, i, err)
+		}
+		copy(current[i+1:from+1], current[i:from])
+		current[i] = trackId
+		moves++
+	}
+	return moves, nil
+}
 
-	// Set Query Params
-	q := url.Values{}
-	q.Add("countryCode", countryCode)
-	q.Add("limit", "20")
-	q.Add("query", fmt.Sprintf("%s %s", albumTitle, albumArtist))
-	q.Add("types", "ALBUMS")
+// playlistOrder returns the current tracks sorted by the first position in trackIds.
+func playlistOrder(current []int64, trackIds []int64) []int64 {
+	remaining := make(map[int64]int, len(current))
+	for _, id := range current {
+		remaining[id]++
+	}
 
-	req.URL.RawQuery = q.Encode()
+	order := make([]int64, 0, len(current))
+	for _, id := range trackIds {
+		// Take as many copies as the playlist has
+		for remaining[id] > 0 {
+			order = append(order, id)
+			remaining[id]--
+		}
+	}
+	for _, id := range current {
+		if remaining[id] > 0 {
+			order = append(order, id)
+			remaining[id]--
+		}
+	}
+	return order
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+// playlistTarget names a playlist in the plan
+func (s *Service) playlistTarget(playlistId string) string {
+	if playlist, ok := s.planned[playlistId]; ok {
+		return fmt.Sprintf("Tidal playlist %s (new)", playlist.Title)
 	}
+	if title, ok := s.titles[playlistId]; ok {
+		return fmt.Sprintf("Tidal playlist %s", title)
+	}
+	return fmt.Sprintf("Tidal playlist %s", playlistId)
+}
 
-	defer resp.Body.Close()
+func (s *Service) FindAlbum(ctx context.Context, albumTitle, albumArtist string) (*TrackSearch, error) {
+	log.Debug().Msgf("Searching for album %s by %s", albumTitle, albumArtist)
+
+	params := url.Values{}
+	params.Add("limit", "20")
+	params.Add("query", fmt.Sprintf("%s %s", albumTitle, albumArtist))
+	params.Add("types", "ALBUMS")
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := s.standardHttpGetRequest(ctx, fmt.Sprintf("%s/search", apiURL), params)
 	if err != nil {
 		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("%s", string(body))
-	}
-
 	var albumSearch *TrackSearch
 
 	err = json.Unmarshal(body, &albumSearch)
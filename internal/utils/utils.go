@@ -1,11 +1,21 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/rs/zerolog/log"
+	"github.com/zibbp/music-utils/internal/cache"
+	"github.com/zibbp/music-utils/internal/diff"
+	"github.com/zibbp/music-utils/internal/explain"
+	"github.com/zibbp/music-utils/internal/file"
+	"github.com/zibbp/music-utils/internal/matcher"
+	"github.com/zibbp/music-utils/internal/metrics"
+	"github.com/zibbp/music-utils/internal/overrides"
 	"github.com/zibbp/music-utils/internal/tidal"
 	spotifyPkg "github.com/zmb3/spotify/v2"
 )
@@ -28,125 +38,343 @@ func spotifyTrackInTidalPlaylist(a string, list tidal.TidalPlaylistTracks) (bool
 	return false, 0
 }
 
-func SpotifyToTidalSearch(tidalService *tidal.Service, track spotifyPkg.PlaylistTrack, tidalPlaylist tidal.Playlist, tidalPlaylistTracks tidal.TidalPlaylistTracks, missingTracks *[]*spotifyPkg.PlaylistTrack) {
+func tidalTrackInPlaylist(id int64, list tidal.TidalPlaylistTracks) bool {
+	for _, b := range list.Items {
+		if b.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// TidalSearch holds what is needed to find Spotify tracks on Tidal
+type TidalSearch struct {
+	Service   *tidal.Service
+	Matcher   *matcher.Matcher
+	Cache     *cache.Cache
+	Overrides *overrides.Overrides
+	Explain   *explain.Writer
+}
+
+// PlaylistImport collects the results of importing a Spotify playlist to a Tidal playlist
+type PlaylistImport struct {
+	Playlist tidal.Playlist
+	Tracks   tidal.TidalPlaylistTracks
+	Missing  []*spotifyPkg.PlaylistTrack
+	Matched  []file.MatchedTrack
+	// TrackIds are the Tidal tracks to add to the playlist
+	TrackIds []int64
+	// Errors are the errors of the import, for the run report
+	Errors []string
+}
+
+func SpotifyToTidalSearch(ctx context.Context, search TidalSearch, track spotifyPkg.PlaylistTrack, playlistImport *PlaylistImport) {
+	tidalPlaylist, tidalPlaylistTracks := playlistImport.Playlist, playlistImport.Tracks
+	source := matcher.FromSpotifyTrack(track.Track)
+	trace := explain.Trace{Playlist: tidalPlaylist.Title, Source: source}
+	defer func() {
+		// A dry run changes nothing, so it is not counted
+		if search.Service.Plan == nil {
+			switch trace.Decision {
+			case explain.DecisionMissing, explain.DecisionCachedMissing:
+				metrics.Inc(metrics.TracksMissing, "target", "tidal")
+			case explain.DecisionMatched:
+				metrics.Inc(metrics.TracksMatched, "target", "tidal", "strategy", "search")
+			case explain.DecisionError:
+			default:
+				metrics.Inc(metrics.TracksMatched, "target", "tidal", "strategy", trace.Decision)
+			}
+		}
+		err := search.Explain.Write(trace)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error writing explain trace for track %s", track.Track.Name)
+		}
+	}()
+	// Check for a manual override
+	if tidalID, ok := search.Overrides.TidalTrack(source.ID, source.ISRC); ok {
+		result := matcher.Result{
+			Candidate:  matcher.Track{ID: strconv.FormatInt(tidalID, 10)},
+			Confidence: 1,
+		}
+		trace.Decision = explain.DecisionOverride
+		if tidalTrackInPlaylist(tidalID, tidalPlaylistTracks) {
+			log.Debug().Msgf("Overridden track %s already in playlist %s", track.Track.Name, tidalPlaylist.Title)
+			playlistImport.Matched = append(playlistImport.Matched, newMatchedTrack(source, &result, file.MatchStatusInPlaylist))
+			return
+		}
+		log.Debug().Msgf("Using override %d for track %s", tidalID, track.Track.Name)
+		playlistImport.TrackIds = append(playlistImport.TrackIds, tidalID)
+		playlistImport.Matched = append(playlistImport.Matched, newMatchedTrack(source, &result, file.MatchStatusAdded))
+		return
+	}
 	// Check if track is already in Tidal playlist
-	inPlaylist, _ := spotifyTrackInTidalPlaylist(track.Track.Name, tidalPlaylistTracks)
+	inPlaylist, i := spotifyTrackInTidalPlaylist(track.Track.Name, tidalPlaylistTracks)
 	if inPlaylist {
 		log.Debug().Msgf("Track %s already in playlist %s", track.Track.Name, tidalPlaylist.Title)
+		result := search.Matcher.Score(source, matcher.FromTidalTrack(tidalPlaylistTracks.Items[i]))
+		trace.Decision = explain.DecisionInPlaylist
+		trace.Candidates = []matcher.Result{result}
+		playlistImport.Matched = append(playlistImport.Matched, newMatchedTrack(source, &result, file.MatchStatusInPlaylist))
+		return
+	}
+	// Check if the track was resolved in a previous run
+	cacheKeys := []string{cache.SpotifyKey(source.ID)}
+	if source.ISRC != "" {
+		cacheKeys = append(cacheKeys, cache.ISRCKey(source.ISRC))
+	}
+	// The cache only returns entries of a matcher with the same scoring and threshold
+	if entry, ok := search.Cache.Get(cacheKeys...); ok {
+		result := matcher.Result{
+			Candidate:  matcher.Track{ID: strconv.FormatInt(entry.TidalID, 10), Title: entry.TidalTitle, Artists: entry.TidalArtists},
+			Confidence: entry.Confidence,
+		}
+		if !entry.Found() {
+			log.Debug().Msgf("Track %s is cached as missing", track.Track.Name)
+			trace.Decision = explain.DecisionCachedMissing
+			playlistImport.Matched = append(playlistImport.Matched, newMatchedTrack(source, nil, file.MatchStatusMissing))
+			playlistImport.Missing = append(playlistImport.Missing, &track)
+			return
+		}
+		log.Debug().Msgf("Found cached match for track %s on Tidal", track.Track.Name)
+		trace.Decision = explain.DecisionCached
+		trace.Candidates = []matcher.Result{result}
+		// The title in the playlist can differ from the Spotify title
+		if tidalTrackInPlaylist(entry.TidalID, tidalPlaylistTracks) {
+			log.Debug().Msgf("Cached match for track %s already in playlist %s", track.Track.Name, tidalPlaylist.Title)
+			playlistImport.Matched = append(playlistImport.Matched, newMatchedTrack(source, &result, file.MatchStatusInPlaylist))
+			return
+		}
+		playlistImport.TrackIds = append(playlistImport.TrackIds, entry.TidalID)
+		playlistImport.Matched = append(playlistImport.Matched, newMatchedTrack(source, &result, file.MatchStatusAdded))
 		return
 	}
 	// Search for track on Tidal
-	tidalTrack, err := tidalService.SearchTracks(fmt.Sprintf("%s %s", track.Track.Name, track.Track.Artists[0].Name))
+	trace.Query = fmt.Sprintf("%s %s", track.Track.Name, track.Track.Artists[0].Name)
+	tidalTrack, err := search.Service.SearchTracks(ctx, trace.Query)
 	if err != nil {
-		log.Error().Msgf("Error searching for track %s: %w", track.Track.Name, err)
+		log.Error().Err(err).Msgf("Error searching for track %s", track.Track.Name)
+		trace.Decision, trace.Error = explain.DecisionError, err.Error()
+		playlistImport.Errors = append(playlistImport.Errors, fmt.Sprintf("error searching for track %s: %s", track.Track.Name, err))
+		playlistImport.Matched = append(playlistImport.Matched, newMatchedTrack(source, nil, file.MatchStatusError))
 		return
 	}
-	if len(tidalTrack.Tracks.Items) > 0 {
-		var added bool
-		// Check if search results contain the track ISRC code
-		for _, item := range tidalTrack.Tracks.Items {
-			// Case insensitive comparison
-			c := strings.EqualFold(item.Isrc, track.Track.ExternalIDs["isrc"])
-			if c {
-				log.Debug().Msgf("Found matching track %s on Tidal", track.Track.Name)
-				// Add track to Tidal playlist
-				err = tidalService.AddTrackToPlaylist(tidalPlaylist.UUID, item.ID)
-				if err != nil {
-					log.Error().Msgf("Error adding track %s to playlist %s: %w", track.Track.Name, tidalPlaylist.Title, err)
-					return
-				}
-				added = true
-				break
-			} else {
-				// Begin the hell that is trying to match songs between platforms :(
-				// Compare first 4 characters of ISRC
-				if len(item.Isrc) < 4 || len(track.Track.ExternalIDs["isrc"]) < 4 || item.Isrc == "" || track.Track.ExternalIDs["isrc"] == "" {
-					log.Info().Msgf("ISRC code for track %s is invalid", track.Track.Name)
-					continue
-				}
-				c = strings.EqualFold(item.Isrc[:4], track.Track.ExternalIDs["isrc"][:4])
-				if c {
-					log.Debug().Msgf("Found matching track %s on Tidal", track.Track.Name)
-					// Add track to Tidal playlist
-					err = tidalService.AddTrackToPlaylist(tidalPlaylist.UUID, item.ID)
-					if err != nil {
-						log.Error().Err(err).Msg("Couldn't add track to Tidal playlist")
-						return
-					}
-					added = true
-					break
-				}
-				// Compare track name and artist with "top hit"
-				title := strings.EqualFold(tidalTrack.TopHit.Value.Title, track.Track.Name)
-				artist := strings.EqualFold(tidalTrack.TopHit.Value.Artists[0].Name, track.Track.Artists[0].Name)
-				if title && artist {
-					log.Debug().Msgf("Found matching track %s on Tidal", track.Track.Name)
-					// Add track to Tidal playlist
-					err = tidalService.AddTrackToPlaylist(tidalPlaylist.UUID, item.ID)
-					if err != nil {
-						log.Error().Err(err).Msg("Couldn't add track to Tidal playlist")
-						return
-					}
-					added = true
-					break
-				}
-				// Compare top hit removing and, (, or [ from title
-				topHit := strings.Split(item.Title, " (")
-				topHit = strings.Split(topHit[0], " [")
-				artist = strings.EqualFold(item.Artists[0].Name, track.Track.Artists[0].Name)
-				titleCompare := strings.EqualFold(topHit[0], track.Track.Name)
-				if titleCompare && artist {
-					log.Debug().Msgf("Found matching track %s on Tidal", track.Track.Name)
-					// Add track to Tidal playlist
-					err = tidalService.AddTrackToPlaylist(tidalPlaylist.UUID, item.ID)
-					if err != nil {
-						log.Error().Err(err).Msg("Couldn't add track to Tidal playlist")
-						return
-					}
-					added = true
-					break
-				}
-				// If last character is S, remove it and compare
-				if strings.HasSuffix(track.Track.Name, "s") {
-					titleCompareSuffix := strings.EqualFold(topHit[0], strings.TrimSuffix(track.Track.Name, "s"))
-					if titleCompareSuffix && artist {
-						log.Debug().Msgf("Found matching track %s on Tidal", track.Track.Name)
-						// Add track to Tidal playlist
-						err = tidalService.AddTrackToPlaylist(tidalPlaylist.UUID, item.ID)
-						if err != nil {
-							log.Error().Err(err).Msg("Couldn't add track to Tidal playlist")
-							return
-						}
-						added = true
-						break
-					}
-				}
-				// If second artist is present, compare
-				if len(track.Track.Artists) > 1 {
-					artist = strings.EqualFold(tidalTrack.TopHit.Value.Artists[0].Name, track.Track.Artists[1].Name)
-					if titleCompare && artist {
-						log.Debug().Msgf("Found matching track %s on Tidal", track.Track.Name)
-						// Add track to Tidal playlist
-						err = tidalService.AddTrackToPlaylist(tidalPlaylist.UUID, item.ID)
-						if err != nil {
-							log.Error().Err(err).Msg("Couldn't add track to Tidal playlist")
-							return
-						}
-						added = true
-						break
-					}
-				}
+	// Score every search result and keep the best one
+	var candidates []matcher.Track
+	for _, item := range tidalTrack.Tracks.Items {
+		candidates = append(candidates, matcher.FromTidalTrack(item))
+	}
+	trace.Candidates = search.Matcher.Evaluate(source, candidates)
+	result, ok := search.Matcher.Pick(trace.Candidates)
+	if !ok {
+		trace.Decision = explain.DecisionMissing
+		if len(candidates) > 0 {
+			log.Debug().Msgf("Best match for track %s scored %.2f which is below the threshold of %.2f", track.Track.Name, result.Confidence, search.Matcher.Threshold)
+			playlistImport.Matched = append(playlistImport.Matched, newMatchedTrack(source, &result, file.MatchStatusMissing))
+		} else {
+			playlistImport.Matched = append(playlistImport.Matched, newMatchedTrack(source, nil, file.MatchStatusMissing))
+		}
+		// Remember that the track could not be found
+		err = search.Cache.Put(cache.Entry{Confidence: result.Confidence}, cacheKeys...)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error caching missing track %s", track.Track.Name)
+		}
+		// Add track to missing tracks
+		playlistImport.Missing = append(playlistImport.Missing, &track)
+		return
+	}
+	item := tidalTrack.Tracks.Items[result.Index]
+	err = search.Cache.Put(cache.Entry{TidalID: item.ID, TidalTitle: item.Title, TidalArtists: result.Candidate.Artists, Confidence: result.Confidence}, cacheKeys...)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error caching match for track %s", track.Track.Name)
+	}
+	log.Debug().Msgf("Found matching track %s on Tidal with confidence %.2f", track.Track.Name, result.Confidence)
+	trace.Decision = explain.DecisionMatched
+	if tidalTrackInPlaylist(item.ID, tidalPlaylistTracks) {
+		log.Debug().Msgf("Matching track %s already in playlist %s", track.Track.Name, tidalPlaylist.Title)
+		playlistImport.Matched = append(playlistImport.Matched, newMatchedTrack(source, &result, file.MatchStatusInPlaylist))
+		return
+	}
+	// Queue track to be added to the Tidal playlist
+	playlistImport.TrackIds = append(playlistImport.TrackIds, item.ID)
+	playlistImport.Matched = append(playlistImport.Matched, newMatchedTrack(source, &result, file.MatchStatusAdded))
+}
+
+// SkipTrack records a track that is not looked up because it has no Spotify ID, such as a local file.
+// A Tidal track with the same title in the playlist is kept for it.
+func SkipTrack(track spotifyPkg.PlaylistTrack, playlistImport *PlaylistImport) {
+	matchedTrack := newMatchedTrack(matcher.FromSpotifyTrack(track.Track), nil, file.MatchStatusError)
+	if inPlaylist, i := spotifyTrackInTidalPlaylist(track.Track.Name, playlistImport.Tracks); inPlaylist {
+		matchedTrack.TidalID = strconv.FormatInt(playlistImport.Tracks.Items[i].ID, 10)
+	}
+	playlistImport.Matched = append(playlistImport.Matched, matchedTrack)
+}
+
+// KeepPreviousMatches gives tracks whose lookup failed the Tidal track of their previous match.
+func KeepPreviousMatches(matchedTracks []file.MatchedTrack, previousTracks []file.MatchedTrack) {
+	previous := make(map[string]file.MatchedTrack, len(previousTracks))
+	for _, previousTrack := range previousTracks {
+		if previousTrack.SpotifyID != "" && previousTrack.TidalID != "" && previousTrack.Status != file.MatchStatusMissing {
+			previous[previousTrack.SpotifyID] = previousTrack
+		}
+	}
+	for i := range matchedTracks {
+		previousTrack, ok := previous[matchedTracks[i].SpotifyID]
+		if matchedTracks[i].Status != file.MatchStatusError || matchedTracks[i].TidalID != "" || !ok {
+			continue
+		}
+		matchedTracks[i].TidalID = previousTrack.TidalID
+		matchedTracks[i].TidalTitle = previousTrack.TidalTitle
+		matchedTracks[i].TidalArtists = previousTrack.TidalArtists
+		matchedTracks[i].Confidence = previousTrack.Confidence
+	}
+}
+
+func newMatchedTrack(source matcher.Track, result *matcher.Result, status string) file.MatchedTrack {
+	matchedTrack := file.MatchedTrack{
+		SpotifyID: source.ID,
+		ISRC:      source.ISRC,
+		Name:      source.Title,
+		Artists:   source.Artists,
+		Album:     source.Album,
+		Duration:  source.Duration,
+		Status:    status,
+	}
+	if result != nil {
+		matchedTrack.TidalID = result.Candidate.ID
+		matchedTrack.TidalTitle = result.Candidate.Title
+		matchedTrack.TidalArtists = result.Candidate.Artists
+		matchedTrack.Confidence = result.Confidence
+	}
+	return matchedTrack
+}
+
+// MarkFailedTracks sets the status of added tracks that are not in the Tidal playlist to failed.
+func MarkFailedTracks(matchedTracks []file.MatchedTrack, tidalPlaylistTracks tidal.TidalPlaylistTracks) {
+	inPlaylist := make(map[string]bool, len(tidalPlaylistTracks.Items))
+	for _, item := range tidalPlaylistTracks.Items {
+		inPlaylist[strconv.FormatInt(item.ID, 10)] = true
+	}
+	for i := range matchedTracks {
+		if matchedTracks[i].Status == file.MatchStatusAdded && !inPlaylist[matchedTracks[i].TidalID] {
+			matchedTracks[i].Status = file.MatchStatusFailed
+		}
+	}
+}
+
+// TidalOrder returns the Tidal IDs of the matched tracks in source playlist order.
+func TidalOrder(matchedTracks []file.MatchedTrack) []int64 {
+	var trackIds []int64
+	for _, matchedTrack := range matchedTracks {
+		if matchedTrack.Status != file.MatchStatusAdded && matchedTrack.Status != file.MatchStatusInPlaylist && matchedTrack.Status != file.MatchStatusError {
+			continue
+		}
+		id, err := strconv.ParseInt(matchedTrack.TidalID, 10, 64)
+		if err != nil {
+			continue
+		}
+		trackIds = append(trackIds, id)
+	}
+	return trackIds
+}
+
+// SyncSource returns the Tidal IDs that belong in the playlist. Tracks that could not be matched
+// this time keep the Tidal track of their previous match, so they are not removed. It returns false
+// when the lookup of a track failed and its Tidal track is not known, then nothing may be removed.
+func SyncSource(matchedTracks []file.MatchedTrack, previousTracks []file.MatchedTrack) ([]int64, bool) {
+	previous := make(map[string]string, len(previousTracks))
+	for _, previousTrack := range previousTracks {
+		if previousTrack.TidalID != "" && previousTrack.Status != file.MatchStatusMissing {
+			previous[previousTrack.SpotifyID] = previousTrack.TidalID
+		}
+	}
+
+	var trackIds []int64
+	for _, matchedTrack := range matchedTracks {
+		tidalID := matchedTrack.TidalID
+		switch matchedTrack.Status {
+		case file.MatchStatusMissing, file.MatchStatusFailed:
+			tidalID = previous[matchedTrack.SpotifyID]
+		case file.MatchStatusError:
+			if tidalID == "" {
+				tidalID = previous[matchedTrack.SpotifyID]
+			}
+			// Tracks without a Spotify ID are never looked up, so they were never added
+			if tidalID == "" && matchedTrack.SpotifyID != "" {
+				return nil, false
+			}
+		}
+		id, err := strconv.ParseInt(tidalID, 10, 64)
+		if err != nil {
+			continue
+		}
+		trackIds = append(trackIds, id)
+	}
+	return trackIds, true
+}
+
+// RemoveTidalTracks removes the tracks of the Tidal playlist that are not in trackIds.
+// It returns the number of tracks removed.
+func RemoveTidalTracks(ctx context.Context, tidalService *tidal.Service, tidalPlaylist tidal.Playlist, tidalPlaylistTracks tidal.TidalPlaylistTracks, trackIds []int64) (int, error) {
+	current := make([]int64, len(tidalPlaylistTracks.Items))
+	for i, item := range tidalPlaylistTracks.Items {
+		current[i] = item.ID
+	}
+	playlistDiff := diff.Compute(trackIds, current)
+	log.Info().Msgf("Playlist %s on Tidal: %d to add, %d to remove, %d unchanged", tidalPlaylist.Title, len(playlistDiff.Added), len(playlistDiff.Removed), len(playlistDiff.Unchanged))
+	if len(playlistDiff.Removed) == 0 {
+		return 0, nil
+	}
+	action := "Removing"
+	if tidalService.Plan != nil {
+		action = "Would remove"
+	}
+	for _, index := range playlistDiff.Removed {
+		item := tidalPlaylistTracks.Items[index]
+		log.Info().Msgf("%s %s - %s (%d) from Tidal playlist %s", action, item.Artist.Name, item.Title, item.ID, tidalPlaylist.Title)
+	}
+	err := tidalService.RemovePlaylistItems(ctx, tidalPlaylist.UUID, playlistDiff.Removed)
+	if err != nil {
+		return 0, err
+	}
+	return len(playlistDiff.Removed), nil
+}
+
+// SyncM3U8Playlist writes the track paths to the playlist file and returns the difference with the file. Without
+// remove new tracks are appended and tracks no longer in the playlist are kept. With remove only the lines in
+// removable are removed, other lines that are not in trackPaths are kept after them.
+func SyncM3U8Playlist(name string, trackPaths []string, remove bool, removable map[string]bool) (diff.Diff[string], error) {
+	existing, err := file.ReadM3U8PlaylistFile(name)
+	if err != nil {
+		return diff.Diff[string]{}, err
+	}
+	playlistDiff := diff.Compute(trackPaths, existing)
+	if remove {
+		// Keep the lines whose track still exists but was not found this time, or that were added by hand
+		kept := slices.Clone(trackPaths)
+		for _, index := range playlistDiff.Removed {
+			if !removable[existing[index]] {
+				kept = append(kept, existing[index])
 			}
+		}
+		trackPaths = kept
+		playlistDiff = diff.Compute(trackPaths, existing)
+	}
+	log.Info().Msgf("Playlist %s in Navidrome: %d to add, %d to remove, %d unchanged", name, len(playlistDiff.Added), len(playlistDiff.Removed), len(playlistDiff.Unchanged))
 
+	if remove {
+		for _, index := range playlistDiff.Removed {
+			log.Info().Msgf("Removing %s from playlist file %s", existing[index], name)
 		}
-		if !added {
-			// Add track to missing tracks
-			*missingTracks = append(*missingTracks, &track)
+		return playlistDiff, file.WriteM3U8PlaylistFile(name, trackPaths)
+	}
+
+	for _, trackPath := range playlistDiff.Added {
+		err := file.AddTrackToM3U8PlaylistFile(name, trackPath)
+		if err != nil {
+			return playlistDiff, err
 		}
-	} else {
-		// Add track to missing tracks
-		*missingTracks = append(*missingTracks, &track)
 	}
+	return playlistDiff, nil
 }
 
 func ExtractUUID(url string) string {
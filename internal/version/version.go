@@ -0,0 +1,161 @@
+package version
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zibbp/music-utils/internal/normalize"
+)
+
+type Kind string
+
+const (
+	Live         Kind = "live"
+	Remix        Kind = "remix"
+	Acoustic     Kind = "acoustic"
+	Demo         Kind = "demo"
+	Instrumental Kind = "instrumental"
+	RadioEdit    Kind = "radio_edit"
+	RadioMix     Kind = "radio_mix"
+	ExtendedMix  Kind = "extended_mix"
+	OriginalMix  Kind = "original_mix"
+	Remaster     Kind = "remaster"
+	Explicit     Kind = "explicit"
+	Clean        Kind = "clean"
+)
+
+// significant kinds are different recordings, a track is only equivalent to a track with the same kinds.
+// Remasters, original mixes and explicit or clean editions are the same recording.
+var significant = []Kind{Live, Remix, Acoustic, Demo, Instrumental, RadioEdit, RadioMix, ExtendedMix}
+
+var (
+	// Text in brackets, e.g. "(Live)" or "[Tiësto Remix]"
+	bracketRegex = regexp.MustCompile(`[\(\[]([^\)\]]+)[\)\]]`)
+	remixRegex   = regexp.MustCompile(`^(.*?)\s*\b(?:remix|rmx)\b`)
+	yearRegex    = regexp.MustCompile(`\b(19|20)\d{2}\b`)
+)
+
+// Version describes the version tags of a track title.
+type Version struct {
+	Kinds        map[Kind]bool
+	Remixer      string
+	RemasterYear int
+}
+
+// Parse classifies the version tags found in the bracketed parts and " - " suffixes of a title.
+// Additional version strings, like Tidal's version field, are classified as well.
+func Parse(title string, versions ...string) Version {
+	v := Version{Kinds: make(map[Kind]bool)}
+	for _, segment := range segments(title) {
+		v.classify(segment)
+	}
+	for _, version := range versions {
+		v.classify(normalize.Fold(version))
+	}
+	return v
+}
+
+// segments returns the folded bracket contents and dash suffixes of a title.
+func segments(title string) []string {
+	title = normalize.Fold(title)
+	var parts []string
+	for _, match := range bracketRegex.FindAllStringSubmatch(title, -1) {
+		parts = append(parts, match[1])
+	}
+	title = bracketRegex.ReplaceAllString(title, "")
+	dash := strings.Split(title, " - ")
+	parts = append(parts, dash[1:]...)
+	return parts
+}
+
+func (v *Version) classify(segment string) {
+	segment = strings.TrimSpace(segment)
+	words := strings.Fields(strings.NewReplacer("-", " ", ".", " ", ",", " ").Replace(segment))
+	has := func(word string) bool {
+		for _, w := range words {
+			if w == word {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case strings.Contains(segment, "remaster"):
+		v.Kinds[Remaster] = true
+		if year := yearRegex.FindString(segment); year != "" {
+			v.RemasterYear, _ = strconv.Atoi(year)
+		}
+	case strings.Contains(segment, "radio edit") || strings.Contains(segment, "radio version") || strings.Contains(segment, "single edit"):
+		v.Kinds[RadioEdit] = true
+	case has("mix") && has("original"):
+		v.Kinds[OriginalMix] = true
+	case has("mix") && has("extended"):
+		v.Kinds[ExtendedMix] = true
+	case has("mix") && has("radio"):
+		v.Kinds[RadioMix] = true
+	case segment == "original version" || segment == "album version":
+		// The original recording
+	case has("remix") || has("rmx"):
+		v.Kinds[Remix] = true
+		if match := remixRegex.FindStringSubmatch(segment); match != nil {
+			v.Remixer = normalize.Artist(match[1])
+		}
+	}
+	if has("live") {
+		v.Kinds[Live] = true
+	}
+	if has("acoustic") || has("unplugged") {
+		v.Kinds[Acoustic] = true
+	}
+	if has("demo") {
+		v.Kinds[Demo] = true
+	}
+	if has("instrumental") {
+		v.Kinds[Instrumental] = true
+	}
+	if has("explicit") {
+		v.Kinds[Explicit] = true
+	}
+	if has("clean") {
+		v.Kinds[Clean] = true
+	}
+}
+
+// String lists the significant kinds of the version, "original" if there are none.
+func (v Version) String() string {
+	var kinds []string
+	for _, kind := range significant {
+		if !v.Kinds[kind] {
+			continue
+		}
+		if kind == Remix && v.Remixer != "" {
+			kinds = append(kinds, fmt.Sprintf("%s remix", v.Remixer))
+			continue
+		}
+		kinds = append(kinds, string(kind))
+	}
+	if len(kinds) == 0 {
+		return "original"
+	}
+	sort.Strings(kinds)
+	return strings.Join(kinds, ", ")
+}
+
+// Compatible reports whether two versions are the same recording. Remasters and
+// explicit or clean editions are equivalent, live versions, remixes and other
+// significant kinds are only equivalent to the same kind. Remixes by different
+// remixers are not equivalent.
+func Compatible(a, b Version) bool {
+	for _, kind := range significant {
+		if a.Kinds[kind] != b.Kinds[kind] {
+			return false
+		}
+	}
+	if a.Kinds[Remix] && a.Remixer != "" && b.Remixer != "" && a.Remixer != b.Remixer {
+		return false
+	}
+	return true
+}